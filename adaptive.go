@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// reviewCard holds the spaced-repetition schedule for one interval or
+// interval pair. The scheduling follows the SM-2 algorithm.
+type reviewCard struct {
+	Key       string    `json:"key"`       // interval name or "name1-name2" pair
+	Ease      float64   `json:"ease"`      // SM-2 easiness factor, >= 1.3
+	Days      int       `json:"days"`      // current review interval in days
+	Reps      int       `json:"reps"`      // consecutive successful reviews
+	Due       time.Time `json:"due"`       // next scheduled review
+	LastGrade int       `json:"lastGrade"` // 0 (forgot) to 5 (perfect)
+}
+
+var cardsBucket = []byte("cards")
+
+// minEase is the lowest easiness factor SM-2 allows.
+const minEase = 1.3
+
+// newReviewCard returns a card for key that has never been reviewed.
+func newReviewCard(key string) reviewCard {
+	return reviewCard{Key: key, Ease: 2.5}
+}
+
+// review updates the card's schedule for a grade from 0 to 5 given at time
+// now. Grades below 3 mean the item was hard and restart the schedule.
+func (c *reviewCard) review(grade int, now time.Time) {
+	if grade < 3 {
+		c.Reps = 0
+		c.Days = 1
+	} else {
+		c.Reps++
+		switch c.Reps {
+		case 1:
+			c.Days = 1
+		case 2:
+			c.Days = 6
+		default:
+			c.Days = int(math.Round(float64(c.Days) * c.Ease))
+		}
+	}
+	q := float64(5 - grade)
+	c.Ease += 0.1 - q*(0.08+q*0.02)
+	if c.Ease < minEase {
+		c.Ease = minEase
+	}
+	c.LastGrade = grade
+	c.Due = now.AddDate(0, 0, c.Days)
+}
+
+// weight returns the relative frequency with which the card's material
+// should appear in an adaptive etude at time now. Hard and overdue cards
+// weigh more; cards that aren't due yet weigh less.
+func (c *reviewCard) weight(now time.Time) float64 {
+	// Difficulty contributes up to 5 for a card at minimum ease.
+	w := 1 + (2.5-c.Ease)*(4/(2.5-minEase))
+	if w < 1 {
+		w = 1
+	}
+	if now.Before(c.Due) {
+		return w / 4
+	}
+	// Overdue cards gain weight with lateness, up to double.
+	late := now.Sub(c.Due).Hours() / 24
+	return w * (1 + math.Min(late, float64(c.Days+1))/float64(c.Days+1))
+}
+
+// validCardKey returns true if key names an interval or a pair of
+// intervals joined by '-'. Unisons aren't drilled, so they have no cards.
+func validCardKey(key string) bool {
+	names := strings.Split(key, "-")
+	if len(names) > 2 {
+		return false
+	}
+	for _, name := range names {
+		if !validIntervalName(name) || intervalSizeByName(name) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// etudeCards returns the keys of the review cards that e drills: its
+// interval or interval pair, or the cards an adaptive etude was drawn from.
+// Other etudes drill no cards.
+func etudeCards(e servedEtude) []string {
+	var key string
+	switch e.req.pattern {
+	case "adaptive":
+		return e.cards
+	case "interval":
+		key = e.req.interval1
+	case "intervalpair":
+		key = e.req.interval1 + "-" + e.req.interval2
+	default:
+		return []string{}
+	}
+	if !validCardKey(key) {
+		return []string{}
+	}
+	return []string{key}
+}
+
+// cardsHndlr responds to /cards/<seed> with a JSON list of the review card
+// keys drilled by the recently served etude generated from <seed>, so that
+// the web page can rate the etude that was actually played. It gives a 404
+// if the etude is no longer in memory.
+func cardsHndlr(w http.ResponseWriter, r *http.Request) {
+	e, ok := pathEtude(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(etudeCards(e)); err != nil {
+		log.Printf("could not encode cards of etude %d: %v", e.seed, err)
+	}
+}
+
+// reviewCard grades the user's card for key at time now and stores the
+// updated schedule, creating the card if needed.
+func (h *practiceHistory) reviewCard(user, key string, grade int, now time.Time) (card reviewCard, err error) {
+	err = h.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(usersBucket).Get([]byte(user)) == nil {
+			return fmt.Errorf("no such user: %s", user)
+		}
+		b, err := tx.Bucket(cardsBucket).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+		card = newReviewCard(key)
+		if v := b.Get([]byte(key)); v != nil {
+			if err = json.Unmarshal(v, &card); err != nil {
+				return err
+			}
+		}
+		card.review(grade, now)
+		v, err := json.Marshal(card)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), v)
+	})
+	return
+}
+
+// cards returns all of the user's review cards.
+func (h *practiceHistory) cards(user string) (cards []reviewCard, err error) {
+	err = h.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(cardsBucket).Bucket([]byte(user))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var card reviewCard
+			if err := json.Unmarshal(v, &card); err != nil {
+				return err
+			}
+			cards = append(cards, card)
+			return nil
+		})
+	})
+	return
+}
+
+// adaptivePatterns is the number of patterns in an adaptive etude.
+const adaptivePatterns = 24
+
+// adaptiveWeights returns a weight for every simple interval (except
+// unison) and every reviewed compound interval or interval pair. Intervals
+// that have never been reviewed get the weight of a new card so they still
+// turn up.
+func adaptiveWeights(cards []reviewCard, now time.Time) (keys []string, weights []float64) {
+	reviewed := map[string]reviewCard{}
+	for _, c := range cards {
+		reviewed[c.Key] = c
+	}
+	for _, inf := range intervalInfo {
+		if inf.size == 0 || inf.size > 12 {
+			continue
+		}
+		c, ok := reviewed[inf.fileName]
+		if !ok {
+			c = newReviewCard(inf.fileName)
+			c.Due = now
+		}
+		keys = append(keys, inf.fileName)
+		weights = append(weights, c.weight(now))
+		delete(reviewed, inf.fileName)
+	}
+	for _, c := range cards {
+		if _, ok := reviewed[c.Key]; !ok || !validCardKey(c.Key) {
+			continue // already handled above, or a unison
+		}
+		keys = append(keys, c.Key)
+		weights = append(weights, c.weight(now))
+	}
+	return
+}
+
+// weightedChoice returns an index into weights chosen at random with
+// probability proportional to its weight.
+func weightedChoice(weights []float64) int {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	r := rng.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return i
+		}
+		r -= w
+	}
+	return len(weights) - 1
+}
+
+// generateAdaptiveSequence returns an etudeSequence whose patterns are drawn
+// from the user's review cards in proportion to their weights. A single
+// interval card yields an up or down interval pattern like those in
+// generateEqualIntervalSequence. A pair card yields a shuffled triple like
+// those in generateTwoIntervalSequence. Roots are dealt from shuffled
+// chromatic scales so they stay evenly spread.
+func generateAdaptiveSequence(midilo int, midihi int, tempo int, instrument int, req etudeRequest, cards []reviewCard, now time.Time) (sequence etudeSequence) {
+	sequence = etudeSequence{
+		midilo:     midilo,
+		midihi:     midihi,
+		tempo:      tempo,
+		instrument: instrument,
+		req:        req,
+	}
+	keys, weights := adaptiveWeights(cards, now)
+	for i, key := range keys {
+		// compound intervals and pairs of them may not fit the instrument
+		if intervalSpan(strings.Split(key, "-")) > midihi-midilo {
+			weights[i] = 0
+		}
+	}
+	var roots []midiPattern
+	drilled := map[string]bool{}
+	for i := 0; i < adaptivePatterns; i++ {
+		if len(roots) == 0 {
+			for _, p := range getChromaticScale() {
+				roots = append(roots, midiPattern{p})
+			}
+			shufflePatterns(roots)
+		}
+		p := roots[0][0]
+		roots = roots[1:]
+		key := keys[weightedChoice(weights)]
+		if !drilled[key] {
+			drilled[key] = true
+			sequence.cards = append(sequence.cards, key)
+		}
+		names := strings.Split(key, "-")
+		var t midiPattern
+		switch len(names) {
+		case 1:
+			size := intervalSizeByName(names[0])
+			if size > 12 { // keep compound intervals intact
+				t = midiPattern{p, p + size, p}
+				if flip() {
+					t[1] = p - size
+				}
+				break
+			}
+			q := (p + size) % 12
+			t = tripleFromPitchPair(p, q, flip())
+		case 2:
+			t = tripleFrom2Intervals(p, intervalSizeByName(names[0]), intervalSizeByName(names[1]))
+			shufflePatternPitches(&t)
+		}
+		sequence.seq = append(sequence.seq, t)
+	}
+	return
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReviewCard(t *testing.T) {
+	now := time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC)
+	c := newReviewCard("minor3")
+	for i, exp := range []int{1, 6, 16} {
+		c.review(5, now)
+		if c.Days != exp {
+			t.Errorf("review %d: expected %d days, got %d", i, exp, c.Days)
+		}
+	}
+	c.review(1, now)
+	if c.Days != 1 || c.Reps != 0 {
+		t.Errorf("expected a hard grade to restart the schedule, got %+v", c)
+	}
+	for i := 0; i < 10; i++ {
+		c.review(0, now)
+	}
+	if c.Ease != minEase {
+		t.Errorf("expected ease to bottom out at %v, got %v", minEase, c.Ease)
+	}
+}
+
+func TestReviewCardWeight(t *testing.T) {
+	now := time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC)
+	easy := newReviewCard("octave")
+	easy.review(5, now)
+	hard := newReviewCard("tritone")
+	hard.review(0, now)
+	later := now.AddDate(0, 0, 1)
+	if hard.weight(later) <= easy.weight(later) {
+		t.Errorf("expected hard card to outweigh easy card, got %v <= %v", hard.weight(later), easy.weight(later))
+	}
+	if easy.weight(now) >= easy.weight(later) {
+		t.Errorf("expected card to weigh less before it's due")
+	}
+}
+
+func TestValidCardKey(t *testing.T) {
+	for key, exp := range map[string]bool{
+		"minor3":          true,
+		"minor3-major3":   true,
+		"minor3-major3-x": false,
+		"fermented2":      false,
+		"minor3-":         false,
+		"unison":          false,
+		"minor3-unison":   false,
+	} {
+		if validCardKey(key) != exp {
+			t.Errorf("validCardKey(%q): expected %v", key, exp)
+		}
+	}
+}
+
+func TestEtudeCards(t *testing.T) {
+	for _, test := range []struct {
+		e   servedEtude
+		exp []string
+	}{
+		{servedEtude{req: etudeRequest{pattern: "interval", interval1: "minor3"}}, []string{"minor3"}},
+		{servedEtude{req: etudeRequest{pattern: "intervalpair", interval1: "minor3", interval2: "major3"}}, []string{"minor3-major3"}},
+		{servedEtude{req: etudeRequest{pattern: "adaptive"}, cards: []string{"fourth", "minor3-major3"}}, []string{"fourth", "minor3-major3"}},
+		{servedEtude{req: etudeRequest{pattern: "interval", interval1: "unison"}}, []string{}},
+		{servedEtude{req: etudeRequest{pattern: "melody"}}, []string{}},
+	} {
+		if got := etudeCards(test.e); !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("%+v: expected %v, got %v", test.e.req, test.exp, got)
+		}
+	}
+}
+
+func TestCardsRequest(t *testing.T) {
+	resp, err := http.Get("http://" + testhost + "/etude/c/intervalpair/minor3/major3/minor2/trumpet/on/120/1/0")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get("http://" + testhost + "/cards/" + resp.Header.Get("X-Etude-Seed"))
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var cards []string
+	if err = json.NewDecoder(resp.Body).Decode(&cards); err != nil {
+		t.Fatalf("could not decode cards: %v", err)
+	}
+	if exp := []string{"minor3-major3"}; !reflect.DeepEqual(cards, exp) {
+		t.Errorf("expected %v, got %v", exp, cards)
+	}
+}
+
+func TestGenerateAdaptiveSequence(t *testing.T) {
+	now := time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC)
+	var cards []reviewCard
+	// mark everything easy except a minor3-major3 pair
+	for _, inf := range intervalInfo {
+		c := newReviewCard(inf.fileName)
+		for i := 0; i < 4; i++ {
+			c.review(5, now)
+		}
+		cards = append(cards, c)
+	}
+	pair := newReviewCard("minor3-major3")
+	pair.review(0, now.AddDate(0, 0, -2))
+	cards = append(cards, pair)
+
+	seedEtudeRandom(1)
+	s := generateAdaptiveSequence(36, 84, 120, 0, etudeRequest{pattern: "adaptive"}, cards, now)
+	if len(s.seq) != adaptivePatterns {
+		t.Fatalf("expected %d patterns, got %d", adaptivePatterns, len(s.seq))
+	}
+	var pairs int
+	for _, ptn := range s.seq {
+		if len(ptn) != 3 {
+			t.Fatalf("expected triples, got %v", ptn)
+		}
+		if ptn[0] != ptn[2] { // interval patterns return to the first pitch
+			pairs++
+		}
+	}
+	if pairs < adaptivePatterns/2 {
+		t.Errorf("expected the hard pair to dominate, got %d of %d", pairs, adaptivePatterns)
+	}
+	// the etude records each card it drilled once
+	drilled := map[string]bool{}
+	for _, key := range s.cards {
+		if drilled[key] || !validCardKey(key) {
+			t.Errorf("unexpected card %q in %v", key, s.cards)
+		}
+		drilled[key] = true
+	}
+	if !drilled["minor3-major3"] {
+		t.Errorf("expected the hard pair among the cards, got %v", s.cards)
+	}
+	// a hard pair too wide for the range is left out
+	wide := newReviewCard("major13-major13")
+	wide.review(0, now.AddDate(0, 0, -2))
+	s = generateAdaptiveSequence(48, 72, 120, 0, etudeRequest{pattern: "adaptive"}, append(cards, wide), now)
+	for _, ptn := range s.seq {
+		lo, hi := ptn[0], ptn[0]
+		for _, p := range ptn {
+			if p < lo {
+				lo = p
+			}
+			if p > hi {
+				hi = p
+			}
+		}
+		if hi-lo > 24 {
+			t.Errorf("expected patterns within two octaves, got %v", ptn)
+		}
+	}
+}
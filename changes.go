@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// changesPatterns is the approximate number of patterns in a Chord Changes
+// etude. The actual number is rounded up to complete the last pass through
+// the progression.
+const changesPatterns = 24
+
+// Backing track defaults used when a Chord Changes request doesn't specify
+// them.
+const (
+	defaultProgression   = "I-IV-V-I"
+	defaultBackingSound  = "acoustic_grand_piano"
+	defaultBackingVolume = 80
+)
+
+// progressionChoices are the chord progressions offered in the web page.
+// Any progression made of the numerals in romanDegrees is accepted.
+var progressionChoices = []string{"I-IV-V-I", "ii-V-I", "I-vi-IV-V", "I-vi-ii-V", "I-V-vi-IV"}
+
+// romanDegrees maps roman numerals to 0-indexed degrees of a major scale.
+var romanDegrees = map[string]int{"I": 0, "ii": 1, "iii": 2, "IV": 3, "V": 4, "vi": 5}
+
+// progressionDegrees parses a progression like "ii-V-I" and returns the
+// scale degree of each chord.
+func progressionDegrees(name string) (degrees []int, err error) {
+	numerals := strings.Split(name, "-")
+	if len(numerals) < 2 || len(numerals) > 8 {
+		err = fmt.Errorf("progression %q must have 2 to 8 chords", name)
+		return
+	}
+	for _, numeral := range numerals {
+		d, ok := romanDegrees[numeral]
+		if !ok {
+			err = fmt.Errorf("%q is not a supported chord in progression %q", numeral, name)
+			return
+		}
+		degrees = append(degrees, d)
+	}
+	return
+}
+
+// diatonicTriad returns the pitch classes of the triad on the 0-indexed
+// degree of a major scale, root first.
+func diatonicTriad(scale []int, degree int) []int {
+	return []int{scale[degree%7], scale[(degree+2)%7], scale[(degree+4)%7]}
+}
+
+// generateChangesSequence returns a sequence of chord tone patterns in the
+// key of req.tonalCenter that follows the chords of req.progression, one
+// chord per pattern. Each pattern is a random ordering of its chord's tones.
+func generateChangesSequence(midilo int, midihi int, tempo int, instrument int, req etudeRequest) (sequence etudeSequence) {
+	keynum := -1
+	for i, v := range keyNames {
+		if v == req.tonalCenter {
+			keynum = i
+		}
+	}
+	if keynum == -1 {
+		panic(fmt.Sprintf("%s is not a supported pitchname", req.tonalCenter))
+	}
+	degrees, err := progressionDegrees(req.progression)
+	if err != nil {
+		panic(err) // already validated
+	}
+	scale := getScale(keynum, false)
+	sequence = etudeSequence{
+		midilo:     midilo,
+		midihi:     midihi,
+		tempo:      tempo,
+		instrument: instrument,
+		keyname:    req.tonalCenter,
+		req:        req,
+	}
+	passes := (changesPatterns + len(degrees) - 1) / len(degrees)
+	for i := 0; i < passes*len(degrees); i++ {
+		chord := diatonicTriad(scale, degrees[i%len(degrees)])
+		ptn := midiPattern{chord[0], chord[1], chord[2]}
+		shufflePatternPitches(&ptn)
+		sequence.seq = append(sequence.seq, ptn)
+		sequence.chords = append(sequence.chords, chord)
+	}
+	return
+}
+
+// Backing chords are voiced in close position between backingLo and
+// backingLo + 19 over the chord root in the octave below backingLo.
+const backingLo = 48 // C3
+
+// voiceChord returns pitches for the chord tones, pcs, in the inversion
+// that moves least from the previous voicing, prior. If prior is empty it
+// returns root position.
+func voiceChord(pcs []int, prior []int) (voicing []int) {
+	var best int
+	for inv := 0; inv < len(pcs); inv++ {
+		v := make([]int, len(pcs))
+		for i := range pcs {
+			v[i] = backingLo + pcs[(i+inv)%len(pcs)]
+			for i > 0 && v[i] <= v[i-1] {
+				v[i] += 12
+			}
+		}
+		if len(prior) == 0 {
+			return v
+		}
+		var moves int
+		for i := range v {
+			d := v[i] - prior[i]
+			if d < 0 {
+				d = -d
+			}
+			moves += d
+		}
+		if voicing == nil || moves < best {
+			voicing, best = v, moves
+		}
+	}
+	return
+}
+
+// backingTrack returns track data for the chords in sequence.chords played
+// on channel 3 with the root in the bass. Each chord sustains for the bars
+// of its pattern.
+func backingTrack(sequence *etudeSequence) []byte {
+	req := &sequence.req
+	sound := gmFileNamePrefixToNum[req.backingSound]
+	velocity := byte(0x50)
+	barsPerChord := uint32(1 + req.repeats)
+
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0x00, 0xC2, byte(sound)})                   // program change, channel 3
+	buf.Write([]byte{0x00, 0xB2, 0x07, byte(req.backingVolume)}) // channel volume
+	delta := varLen(countInTicks(req))                           // wait for the count-in
+	var prior []int
+	for _, chord := range sequence.chords {
+		voicing := voiceChord(chord, prior)
+		prior = voicing
+		pitches := append([]int{backingLo - 12 + chord[0]}, voicing...)
+		for _, p := range pitches {
+			buf.Write(delta)
+			buf.Write([]byte{0x92, byte(p), velocity})
+			delta = []byte{0x00}
+		}
+		delta = varLen(barsPerChord * ticksPerBar)
+		for _, p := range pitches {
+			buf.Write(delta)
+			buf.Write([]byte{0x82, byte(p), velocity})
+			delta = []byte{0x00}
+		}
+	}
+	buf.Write(delta)
+	buf.Write([]byte{0xff, 0x2f, 0x00}) // end of track
+	return buf.Bytes()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestProgressionDegrees(t *testing.T) {
+	got, err := progressionDegrees("ii-V-I")
+	if err != nil || !reflect.DeepEqual(got, []int{1, 4, 0}) {
+		t.Errorf("expected [1 4 0], got %v, %v", got, err)
+	}
+	for _, name := range []string{"I", "I-VII", "i-IV", "", "I-I-I-I-I-I-I-I-I"} {
+		if _, err := progressionDegrees(name); err == nil {
+			t.Errorf("expected an error for %q", name)
+		}
+	}
+	for _, name := range progressionChoices {
+		if _, err := progressionDegrees(name); err != nil {
+			t.Errorf("%v", err)
+		}
+	}
+}
+
+func TestGenerateChangesSequence(t *testing.T) {
+	req := etudeRequest{tonalCenter: "f", pattern: "changes", progression: "ii-V-I"}
+	s := generateChangesSequence(36, 84, 120, 0, req)
+	if len(s.seq) != changesPatterns || len(s.chords) != len(s.seq) {
+		t.Fatalf("expected %d patterns and chords, got %d, %d", changesPatterns, len(s.seq), len(s.chords))
+	}
+	// ii, V and I in F
+	exp := [][]int{{7, 10, 2}, {0, 4, 7}, {5, 9, 0}}
+	for i, ptn := range s.seq {
+		if !reflect.DeepEqual(s.chords[i], exp[i%3]) {
+			t.Errorf("pattern %d: expected chord %v, got %v", i, exp[i%3], s.chords[i])
+		}
+		pcs := []int{ptn[0], ptn[1], ptn[2]}
+		chord := append([]int{}, s.chords[i]...)
+		sort.Ints(pcs)
+		sort.Ints(chord)
+		if !reflect.DeepEqual(pcs, chord) {
+			t.Errorf("pattern %d: %v doesn't fit chord %v", i, ptn, s.chords[i])
+		}
+	}
+	// the patterns must stay with their chords
+	mkMidi(&s, true)
+	for i, ptn := range s.seq {
+		if ptn[0]%12 != s.chords[i][0] && ptn[0]%12 != s.chords[i][1] && ptn[0]%12 != s.chords[i][2] {
+			t.Errorf("pattern %d: %v moved away from chord %v", i, ptn, s.chords[i])
+		}
+	}
+	os.Remove(s.filename)
+}
+
+func TestVoiceChord(t *testing.T) {
+	c := voiceChord([]int{0, 4, 7}, nil)
+	if !reflect.DeepEqual(c, []int{48, 52, 55}) {
+		t.Errorf("expected root position C major, got %v", c)
+	}
+	// F major after C major moves to second inversion, C-F-A
+	f := voiceChord([]int{5, 9, 0}, c)
+	if !reflect.DeepEqual(f, []int{48, 53, 57}) {
+		t.Errorf("expected C F A, got %v", f)
+	}
+}
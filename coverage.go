@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// coverageReport counts what the patterns of an etude cover. Each map counts
+// patterns by
+//
+//	Roots       the pitch name of the lowest note, e.g. "eflat"
+//	Starts      the pitch name of the first note
+//	Orders      the note order, the rank of each note from the lowest, e.g.
+//	            "201" for a pattern that starts on its highest note
+//	Directions  the contour, u (up), d (down) or s (same) for each step
+//	Registers   the octave of the lowest note, e.g. "4" for C4 (middle C) to B4
+type coverageReport struct {
+	Patterns   int            `json:"patterns"`
+	Roots      map[string]int `json:"roots"`
+	Starts     map[string]int `json:"starts"`
+	Orders     map[string]int `json:"orders"`
+	Directions map[string]int `json:"directions"`
+	Registers  map[string]int `json:"registers"`
+}
+
+// analyzeCoverage returns a coverageReport for the patterns in seq.
+func analyzeCoverage(seq []midiPattern) (c coverageReport) {
+	c = coverageReport{
+		Patterns:   len(seq),
+		Roots:      map[string]int{},
+		Starts:     map[string]int{},
+		Orders:     map[string]int{},
+		Directions: map[string]int{},
+		Registers:  map[string]int{},
+	}
+	for _, ptn := range seq {
+		if len(ptn) == 0 {
+			continue
+		}
+		sorted := append(midiPattern{}, ptn...)
+		sort.Ints(sorted)
+		lo := sorted[0]
+		c.Roots[keyNames[(lo%12+12)%12]]++
+		c.Starts[keyNames[(ptn[0]%12+12)%12]]++
+		c.Registers[strconv.Itoa(lo/12-1)]++
+		// ranks count distinct pitches so repeated notes share a rank
+		ranks := map[int]int{}
+		for _, p := range sorted {
+			if _, ok := ranks[p]; !ok {
+				ranks[p] = len(ranks)
+			}
+		}
+		var order, contour strings.Builder
+		for i, p := range ptn {
+			order.WriteByte(pcDigits[ranks[p]%len(pcDigits)])
+			if i == 0 {
+				continue
+			}
+			switch {
+			case p > ptn[i-1]:
+				contour.WriteByte('u')
+			case p < ptn[i-1]:
+				contour.WriteByte('d')
+			default:
+				contour.WriteByte('s')
+			}
+		}
+		c.Orders[order.String()]++
+		c.Directions[contour.String()]++
+	}
+	return
+}
+
+// coverageRule checks that a coverage report for an etude made from req
+// has the coverage its pattern promises.
+type coverageRule func(c coverageReport, req *etudeRequest) error
+
+// patternCoverage declares the coverage property of every pattern in
+// patternInfo. A nil rule means the pattern makes no promise, e.g. because
+// its patterns are chosen at random. When the request sets a direction,
+// checkCoverage also requires every pattern to follow it.
+var patternCoverage = map[string]coverageRule{
+	"interval":       coversStarts,
+	"allintervals":   nil, // every pattern starts on the tonal center
+	"intervalpair":   coversRootsAndOrders,
+	"intervaltriple": coversRootsAndOrders,
+	"intervalcell":   coversRootsAndOrders,
+	"setclass":       coversRootsAndOrders,
+	"adaptive":       nil, // chosen by the review schedule
+	"changes":        nil, // follows the chord progression
+	"melody":         nil, // random melodies
+	"tonerow":        nil, // random transpositions of the row
+}
+
+// coversStarts requires every pitch name as the first note of the same
+// number of patterns.
+func coversStarts(c coverageReport, req *etudeRequest) error {
+	if err := evenly(c.Starts, 12, c.Patterns); err != nil {
+		return fmt.Errorf("starts: %v", err)
+	}
+	return nil
+}
+
+// coversRootsAndOrders requires each pitch name as the root of the same
+// number of patterns and, when all the patterns have distinct notes and
+// no direction is set, each possible note order the same number of times
+// or, if there are more orders than patterns, a different order for every
+// pattern.
+func coversRootsAndOrders(c coverageReport, req *etudeRequest) error {
+	if err := evenly(c.Roots, 12, c.Patterns); err != nil {
+		return fmt.Errorf("roots: %v", err)
+	}
+	if req.direction != "" && req.direction != directionMixed {
+		return nil // the direction limits the note orders
+	}
+	var notes int
+	for order := range c.Orders {
+		notes = len(order)
+		if strings.Count(order, string(pcDigits[notes-1])) != 1 {
+			return nil // repeated notes, e.g. unisons, have fewer orders
+		}
+	}
+	orders := 1
+	for i := 2; i <= notes && orders < c.Patterns; i++ {
+		orders *= i
+	}
+	if orders > c.Patterns {
+		orders = c.Patterns
+	}
+	if err := evenly(c.Orders, orders, c.Patterns); err != nil {
+		return fmt.Errorf("orders: %v", err)
+	}
+	return nil
+}
+
+// followsDirection requires the contour of every pattern to match the
+// direction of req. Repeated notes match either way, as in matchesContour.
+func followsDirection(c coverageReport, req *etudeRequest) error {
+	for contour := range c.Directions {
+		want := patternContour(req.direction, len(contour)+1)
+		if len(want) != len(contour) {
+			return fmt.Errorf("directions: expected %s, got %s", want, contour)
+		}
+		for i := range contour {
+			if contour[i] != 's' && contour[i] != want[i] {
+				return fmt.Errorf("directions: expected %s, got %s", want, contour)
+			}
+		}
+	}
+	return nil
+}
+
+// evenly returns an error unless counts has n keys that share total equally.
+func evenly(counts map[string]int, n, total int) error {
+	if len(counts) != n {
+		return fmt.Errorf("expected %d kinds, got %d: %v", n, len(counts), counts)
+	}
+	for k, v := range counts {
+		if v*n != total {
+			return fmt.Errorf("expected %s %d times, got %d", k, total/n, v)
+		}
+	}
+	return nil
+}
+
+// checkCoverage returns an error if the patterns of s don't have the
+// coverage declared for its pattern in patternCoverage.
+func checkCoverage(s *etudeSequence) error {
+	rule := patternCoverage[s.req.pattern]
+	if rule == nil {
+		return nil
+	}
+	c := analyzeCoverage(s.seq)
+	if s.req.direction != "" && s.req.direction != directionMixed {
+		if err := followsDirection(c, &s.req); err != nil {
+			return err
+		}
+	}
+	return rule(c, &s.req)
+}
+
+// coverageHndlr responds to /coverage/<seed> with a JSON coverageReport
+// for the recently served etude generated from <seed>. It gives a 404 if
+// the etude is no longer in memory.
+func coverageHndlr(w http.ResponseWriter, r *http.Request) {
+	e, ok := pathEtude(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(analyzeCoverage(e.seq)); err != nil {
+		log.Printf("could not encode coverage of etude %d: %v", e.seed, err)
+	}
+}
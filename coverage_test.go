@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeCoverage(t *testing.T) {
+	c := analyzeCoverage([]midiPattern{{60, 64, 67}, {67, 60, 64}, {63, 51, 63}})
+	exp := coverageReport{
+		Patterns:   3,
+		Roots:      map[string]int{"c": 2, "eflat": 1},
+		Starts:     map[string]int{"c": 1, "g": 1, "eflat": 1},
+		Orders:     map[string]int{"012": 1, "201": 1, "101": 1},
+		Directions: map[string]int{"uu": 1, "du": 2},
+		Registers:  map[string]int{"4": 2, "3": 1},
+	}
+	if !reflect.DeepEqual(c, exp) {
+		t.Errorf("expected %+v, got %+v", exp, c)
+	}
+}
+
+func TestPatternCoverage(t *testing.T) {
+	// every pattern declares its coverage, even if it's none
+	for _, p := range patternInfo {
+		if _, ok := patternCoverage[p.fileName]; !ok {
+			t.Errorf("%s has no coverage declared in patternCoverage", p.fileName)
+		}
+	}
+	var sequences []etudeSequence
+	for _, inf := range intervalInfo {
+		sequences = append(sequences, generateEqualIntervalSequence(36, 84, 120, 0, etudeRequest{pattern: "interval", interval1: inf.fileName}))
+	}
+	for seed := int64(1); seed <= 20; seed++ {
+		seedEtudeRandom(seed)
+		s := generateTwoIntervalSequence(36, 84, 120, 0, "trumpet", 3, 4)
+		s.req = etudeRequest{pattern: "intervalpair"}
+		sequences = append(sequences, s)
+		s = generateThreeIntervalSequence(36, 84, 120, 0, "trumpet", 2, 2, 1)
+		s.req = etudeRequest{pattern: "intervaltriple"}
+		sequences = append(sequences, s)
+		for _, cell := range []string{"minor3-major3", "minor3-major3-minor3", "minor3-major2-perfect4-minor2-major2"} {
+			sequences = append(sequences, generateCellSequence(36, 84, 120, 0, etudeRequest{pattern: "intervalcell", cell: cell}))
+		}
+		for _, sc := range []string{"3-11", "4-Z15", "01358"} {
+			sequences = append(sequences, generateSetClassSequence(36, 84, 120, 0, etudeRequest{pattern: "setclass", setClass: sc}))
+		}
+		// directed patterns keep their roots and follow the direction
+		s = generateThreeIntervalSequence(36, 84, 120, 0, "trumpet", 2, 2, 1)
+		s.req = etudeRequest{pattern: "intervaltriple", direction: directionDescending}
+		orientPatterns(&s)
+		sequences = append(sequences, s)
+		s = generateCellSequence(36, 84, 120, 0, etudeRequest{pattern: "intervalcell", cell: "minor3-major3-minor3", direction: "udu"})
+		orientPatterns(&s)
+		sequences = append(sequences, s)
+	}
+	for _, inf := range intervalInfo {
+		s := generateEqualIntervalSequence(36, 84, 120, 0, etudeRequest{pattern: "interval", interval1: inf.fileName, direction: "du"})
+		orientPatterns(&s)
+		sequences = append(sequences, s)
+	}
+	for _, s := range sequences {
+		if err := checkCoverage(&s); err != nil {
+			t.Errorf("%s: %v", s.req.pattern, err)
+		}
+	}
+	// a missing order breaks the promise
+	s := generateThreeIntervalSequence(36, 84, 120, 0, "trumpet", 2, 2, 1)
+	s.req = etudeRequest{pattern: "intervaltriple"}
+	s.seq[0] = s.seq[1]
+	if checkCoverage(&s) == nil {
+		t.Error("expected an error for a duplicated pattern")
+	}
+	// as does a pattern against the direction
+	s = generateThreeIntervalSequence(36, 84, 120, 0, "trumpet", 2, 2, 1)
+	s.req = etudeRequest{pattern: "intervaltriple", direction: directionDescending}
+	orientPatterns(&s)
+	s.seq[0][0], s.seq[0][1] = s.seq[0][1], s.seq[0][0]
+	if checkCoverage(&s) == nil {
+		t.Error("expected an error for an ascending step in a descending etude")
+	}
+}
+
+func TestCoverageRequest(t *testing.T) {
+	resp, err := http.Get("http://" + testhost + "/etude/c/intervaltriple/minor2/major2/minor3/trumpet/on/120/0/0")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	seed := resp.Header.Get("X-Etude-Seed")
+	resp, err = http.Get("http://" + testhost + "/coverage/" + seed)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	var c coverageReport
+	if err = json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		t.Fatalf("could not decode coverage: %v", err)
+	}
+	if c.Patterns != 24 || len(c.Roots) != 12 || len(c.Orders) != 24 {
+		t.Errorf("expected 24 patterns covering 12 roots and 24 orders, got %+v", c)
+	}
+	resp, err = http.Get("http://" + testhost + "/coverage/1")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code %v, got %v", http.StatusNotFound, resp.StatusCode)
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"os"
 	"reflect"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/Michael-F-Ellis/infinite-etudes/internal/miditempo"
 	"github.com/go-test/deep"
 )
 
@@ -201,6 +206,34 @@ func TestConstrain(t *testing.T) {
 	if diff := deep.Equal(x, exp); diff != nil {
 		t.Errorf("expected %v, got %v", exp, x)
 	}
+	// compound intervals stay intact
+	x = midiPattern{0, 21, 0}
+	prior = 80
+	exp = midiPattern{60, 81, 60}
+	constrain(&x, prior, 55, 84, true)
+	if diff := deep.Equal(x, exp); diff != nil {
+		t.Errorf("expected %v, got %v", exp, x)
+	}
+	// too wide to fit, so the end that sticks out less goes past the limit
+	x = midiPattern{6, 20, 34}
+	prior = 66
+	exp = midiPattern{54, 68, 82}
+	constrain(&x, prior, 60, 84, true)
+	if diff := deep.Equal(x, exp); diff != nil {
+		t.Errorf("expected %v, got %v", exp, x)
+	}
+}
+
+func TestCompoundIntervals(t *testing.T) {
+	s := generateEqualIntervalSequence(36, 84, 120, 0, etudeRequest{pattern: "interval", interval1: "major10"})
+	if len(s.seq) == 0 {
+		t.Fatal("expected some patterns")
+	}
+	for _, ptn := range s.seq {
+		if d := ptn[1] - ptn[0]; (d != 16 && d != -16) || ptn[2] != ptn[0] {
+			t.Errorf("expected a major tenth, got %v", ptn)
+		}
+	}
 }
 func TestMkMidi(t *testing.T) {
 	var x etudeSequence
@@ -391,6 +424,32 @@ func TestMetronomeBars(t *testing.T) {
 	if n != 4*len(exp) {
 		t.Errorf("expected %d bytes, got %d", 4*len(exp), n)
 	}
+	exp4 := []byte{
+		0x99, 0x4b, 0x30, 0x83, 0x60, 0x89, 0x4b, 0x30, 0x00,
+		0x99, 0x4b, 0x08, 0x83, 0x60, 0x89, 0x4b, 0x08, 0x00, // eighth note subdivision
+		0x99, 0x4b, 0x10, 0x83, 0x60, 0x89, 0x4b, 0x10, 0x00,
+	}
+	x = metronomeBars(1, &(etudeRequest{metronome: metronomeEighths, metroSound: "claves"}))
+	if diff := deep.Equal(x.Bytes()[:len(exp4)], exp4); diff != nil {
+		t.Errorf("%v", diff)
+	}
+	if n = len(x.Bytes()); n != 8*9 {
+		t.Errorf("expected %d bytes, got %d", 8*9, n)
+	}
+	x = metronomeBars(1, &(etudeRequest{metronome: metronomeTriplets}))
+	if n = len(x.Bytes()); n != 12*9 {
+		t.Errorf("expected %d bytes, got %d", 12*9, n)
+	}
+	exp5 := []byte{
+		0x99, 0x4d, 0x00, 0x87, 0x40, 0x89, 0x4d, 0x00, 0x00, // silent
+		0x99, 0x4c, 0x30, 0x87, 0x40, 0x89, 0x4c, 0x30, 0x00,
+		0x99, 0x4d, 0x00, 0x87, 0x40, 0x89, 0x4d, 0x00, 0x00, // silent
+		0x99, 0x4c, 0x30, 0x87, 0x40, 0x89, 0x4c, 0x30, 0x00,
+	}
+	x = metronomeBars(1, &(etudeRequest{metronome: metronomeBackbeat}))
+	if diff := deep.Equal(x.Bytes(), exp5); diff != nil {
+		t.Errorf("%v", diff)
+	}
 
 }
 
@@ -481,7 +540,8 @@ func TestExtractIntervalPair(t *testing.T) {
 		{"x-4", false},   // bad i1
 		{"3-x", false},   // bad i2
 		{"0-4", false},   // i1 too low
-		{"3-13", false},  // i2 too high
+		{"3-13", true},   // compound i2
+		{"3-22", false},  // i2 too high
 	}
 	for _, tc := range tcs {
 		_, _, err := extractIntervalPair(tc.s)
@@ -498,3 +558,316 @@ func TestExtractIntervalPair(t *testing.T) {
 
 	}
 }
+
+func TestTempoChanges(t *testing.T) {
+	s := etudeSequence{tempo: 100, seq: []midiPattern{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}}}
+	s.req = etudeRequest{repeats: 1}
+	exp := []tempoChange{{0, 100}}
+	if got := tempoChanges(&s); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	// step every bar: 6 bars after the count-in
+	s.req.rampTo = 150
+	exp = []tempoChange{{0, 100}, {2 * ticksPerBar, 110}, {3 * ticksPerBar, 120}, {4 * ticksPerBar, 130}, {5 * ticksPerBar, 140}, {6 * ticksPerBar, 150}}
+	if got := tempoChanges(&s); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	// step every 2 patterns
+	s.req.rampEvery = 2
+	exp = []tempoChange{{0, 100}, {5 * ticksPerBar, 150}}
+	if got := tempoChanges(&s); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestRampMidiFile(t *testing.T) {
+	req := etudeRequest{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", repeats: 1, rampTo: 180, rampEvery: 3}
+	s := generateEqualIntervalSequence(54, 86, 120, 56, req)
+	mkMidi(&s, true)
+	defer os.Remove(s.filename)
+	data, err := ioutil.ReadFile(s.filename)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tempos, err := miditempo.GetTempoMap(data)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// 12 patterns in 4 steps of 3
+	if len(tempos) != 4 {
+		t.Fatalf("expected 4 tempo events, got %v", tempos)
+	}
+	if tempos[0].MicrosPerBeat != 500000 || tempos[3].MicrosPerBeat != 60000000/180 {
+		t.Errorf("expected tempo to ramp from 120 to 180, got %v", tempos)
+	}
+	if tempos[1].Tick != ticksPerBar+3*2*ticksPerBar {
+		t.Errorf("expected second step after 3 patterns, got tick %d", tempos[1].Tick)
+	}
+}
+
+func TestDroneTrack(t *testing.T) {
+	req := etudeRequest{tonalCenter: "d", pattern: "allintervals", repeats: 1, drone: "fifth", droneSound: "church_organ", droneVolume: 70}
+	s := etudeSequence{tempo: 120, seq: []midiPattern{{62, 64, 62}, {62, 66, 62}}, req: req}
+	exp := []byte{
+		0x00, 0xC1, 19, // church organ
+		0x00, 0xB1, 0x07, 70,
+		0x9e, 0x00, 0x91, 50, 0x60, 0x00, 0x91, 57, 0x60, // D3 and A3 after the count-in
+		0xf8, 0x00, 0x81, 50, 0x60, 0x00, 0x81, 57, 0x60, // 4 bars later
+		0x00, 0xff, 0x2f, 0x00,
+	}
+	if got := droneTrack(&s); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected % x, got % x", exp, got)
+	}
+	data := midiBytes(&s)
+	if data[11] != 4 {
+		t.Errorf("expected 4 tracks, got %d", data[11])
+	}
+	s.req.drone = ""
+	if data = midiBytes(&s); data[11] != 3 {
+		t.Errorf("expected 3 tracks without a drone, got %d", data[11])
+	}
+}
+
+func TestMetronomeGapBars(t *testing.T) {
+	exp := []bool{false, false, true, false, false, true, true, false, false, true, true, true, false, false, true, true, true, true, false, false, true}
+	if got := metronomeGapBars(len(exp), metronomeGaps); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	seedEtudeRandom(1)
+	gaps := metronomeGapBars(200, metronomeRandomGaps)
+	var run, silent int
+	for i, gap := range gaps {
+		if i < gapClickBars && gap {
+			t.Errorf("expected the click in bar %d", i)
+		}
+		if !gap {
+			run = 0
+			continue
+		}
+		silent++
+		if run++; run > maxGapBars {
+			t.Errorf("more than %d silent bars in a row at bar %d", maxGapBars, i)
+		}
+	}
+	if silent < 50 || silent > 150 {
+		t.Errorf("expected about half the bars to be silent, got %d", silent)
+	}
+	if got := metronomeGapBars(3, metronomeOn); !reflect.DeepEqual(got, []bool{false, false, false}) {
+		t.Errorf("expected no gaps, got %v", got)
+	}
+}
+
+func TestMutedBars(t *testing.T) {
+	type testcase struct {
+		req etudeRequest
+		exp []bool
+	}
+	for _, tc := range []testcase{
+		{etudeRequest{repeats: 3, silent: 5}, []bool{false, true, false, true}},
+		{etudeRequest{repeats: 1, silent: 7}, []bool{false, true}},
+		{etudeRequest{repeats: 5, silent: 4}, []bool{false, true, false, false, false, false}},
+		{etudeRequest{repeats: 5, mute: "PPMMPM"}, []bool{false, false, true, true, false, true}},
+	} {
+		if got := mutedBars(&tc.req); !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("%+v: expected %v, got %v", tc.req, tc.exp, got)
+		}
+	}
+	seedEtudeRandom(1)
+	req := etudeRequest{repeats: 8, mute: randomMute}
+	var muted int
+	for i := 0; i < 20; i++ {
+		bars := mutedBars(&req)
+		if len(bars) != 9 || bars[0] {
+			t.Fatalf("expected 9 bars with the first played, got %v", bars)
+		}
+		for _, m := range bars {
+			if m {
+				muted++
+			}
+		}
+	}
+	if muted < 40 || muted > 120 {
+		t.Errorf("expected about half of 160 repeats muted, got %d", muted)
+	}
+	// long patterns must not panic
+	x := nBarsMusic(midiPattern{60, 62, 64}, &etudeRequest{repeats: 7, silent: 2})
+	if n := len(x.Bytes()); n != 8*28 {
+		t.Errorf("expected %d bytes, got %d", 8*28, n)
+	}
+}
+
+func TestCallAndResponse(t *testing.T) {
+	req := etudeRequest{repeats: 1, callSound: "acoustic_grand_piano"}
+	x := nBarsMusic(midiPattern{60, 62, 64}, &req).Bytes()
+	if len(x) != 2*28 {
+		t.Fatalf("expected %d bytes, got %d", 2*28, len(x))
+	}
+	// the call plays on channel 4, the response on channel 1
+	if x[0] != 0x93 || x[5] != 0x83 || x[28] != 0x90 || x[33] != 0x80 {
+		t.Errorf("expected the call on channel 4 and the response on channel 1, got % x", x)
+	}
+	s := etudeSequence{req: req}
+	if got := callInstrument(&s); !reflect.DeepEqual(got, []byte{0x00, 0xC3, 0x00}) {
+		t.Errorf("expected piano on channel 4, got % x", got)
+	}
+	s.req.callSound = ""
+	if got := callInstrument(&s); got != nil {
+		t.Errorf("expected no program change, got % x", got)
+	}
+}
+
+func TestEncodeEvents(t *testing.T) {
+	events := []midiEvent{{0, []byte{0x90, 60, 100}}, {960, []byte{0x80, 60, 100}}, {960, []byte{0x90, 62, 100}}, {1000, []byte{0x80, 62, 100}}}
+	exp := []byte{0x90, 60, 100, 0x87, 0x40, 0x80, 60, 100, 0x00, 0x90, 62, 100, 0x28, 0x80, 62, 100, 0x96, 0x18}
+	if got := encodeEvents(events, 3840); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected % x, got % x", exp, got)
+	}
+}
+
+func TestDynamics(t *testing.T) {
+	// crescendo from 0.6 to 1.2 of the normal velocities
+	x := nBarsMusic(midiPattern{60, 62, 64}, &etudeRequest{repeats: 2, dynamics: dynamicsCrescendo}).Bytes()
+	if x[2] != 61 || x[28+2] != 91 || x[56+2] != 121 {
+		t.Errorf("expected downbeat velocities 61, 91, 121, got %d, %d, %d", x[2], x[28+2], x[56+2])
+	}
+	// terraced dynamics use one level for the whole pattern
+	seedEtudeRandom(1)
+	x = nBarsMusic(midiPattern{60, 62, 64}, &etudeRequest{repeats: 2, dynamics: dynamicsTerraced}).Bytes()
+	if x[2] != x[28+2] || x[2] != x[56+2] {
+		t.Errorf("expected the same level in each bar, got %d, %d, %d", x[2], x[28+2], x[56+2])
+	}
+	// about a quarter of the notes are accented
+	var accents int
+	x = nBarsMusic(midiPattern{60, 62, 64, 65}, &etudeRequest{repeats: 15, dynamics: dynamicsAccents}).Bytes()
+	for i := 0; i < len(x); i += 9 {
+		if x[i+2] > 0x65 {
+			accents++
+		}
+	}
+	if accents < 4 || accents > 32 {
+		t.Errorf("expected about 16 accents in 64 notes, got %d", accents)
+	}
+}
+
+func TestArticulation(t *testing.T) {
+	staccato := []byte{
+		0x90, 60, 0x65, 0x83, 0x60, 0x80, 60, 0x65, 0x83, 0x60,
+		0x90, 62, 0x51, 0x83, 0x60, 0x80, 62, 0x51, 0x83, 0x60,
+		0x90, 64, 0x51, 0x83, 0x60, 0x80, 64, 0x51, 0x8b, 0x20,
+	}
+	x := nBarsMusic(midiPattern{60, 62, 64}, &etudeRequest{articulation: articulationStaccato}).Bytes()
+	if diff := deep.Equal(x, staccato); diff != nil {
+		t.Errorf("%v", diff)
+	}
+	// legato notes overlap the next note unless it repeats the pitch
+	legato := []byte{
+		0x90, 60, 0x65, 0x87, 0x40,
+		0x90, 62, 0x51, 0x3c, // overlap
+		0x80, 60, 0x65, 0x87, 0x04,
+		0x80, 62, 0x51, 0x00, // repeated pitch, no overlap
+		0x90, 62, 0x51, 0x87, 0x40,
+		0x90, 64, 0x51, 0x3c,
+		0x80, 62, 0x51, 0x87, 0x04,
+		0x80, 64, 0x51, 0x00, // no overlap past the end of the bar
+	}
+	x = nBarsMusic(midiPattern{60, 62, 62, 64}, &etudeRequest{articulation: articulationLegato}).Bytes()
+	if diff := deep.Equal(x, legato); diff != nil {
+		t.Errorf("%v", diff)
+	}
+	// tenuto adds a little weight
+	x = nBarsMusic(midiPattern{60, 62, 64}, &etudeRequest{articulation: articulationTenuto}).Bytes()
+	if x[2] != 0x65+8 {
+		t.Errorf("expected velocity %d, got %d", 0x65+8, x[2])
+	}
+}
+
+func TestOrientPatterns(t *testing.T) {
+	seedEtudeRandom(1)
+	s := generateEqualIntervalSequence(48, 84, 120, 0, etudeRequest{pattern: "interval", interval1: "major3", direction: directionDescending})
+	orientPatterns(&s)
+	for _, ptn := range s.seq {
+		if ptn[1]-ptn[0] != -4 || ptn[2] != ptn[0] {
+			t.Errorf("expected a descending major third, got %v", ptn)
+		}
+	}
+	for _, direction := range []string{directionAscending, directionDescending, "ud", "du"} {
+		s = generateTwoIntervalSequence(48, 84, 120, 0, "trumpet", 3, 4)
+		s.req = etudeRequest{pattern: "intervalpair", direction: direction}
+		orientPatterns(&s)
+		contour := patternContour(direction, 3)
+		for _, ptn := range s.seq {
+			if !matchesContour(ptn, contour) {
+				t.Errorf("%s: %v doesn't match %s", direction, ptn, contour)
+			}
+		}
+	}
+	s = generateThreeIntervalSequence(48, 84, 120, 0, "trumpet", 3, 4, 3)
+	s.req = etudeRequest{pattern: "intervaltriple", direction: "udu"}
+	orientPatterns(&s)
+	for _, ptn := range s.seq {
+		if !(ptn[1] > ptn[0] && ptn[2] < ptn[1] && ptn[3] > ptn[2]) {
+			t.Errorf("%v doesn't match udu", ptn)
+		}
+	}
+}
+
+func TestNoteOrders(t *testing.T) {
+	if n := len(permuteIndices(5)); n != 120 {
+		t.Errorf("expected 120 orders of 5 notes, got %d", n)
+	}
+	// few enough to use every order equally often
+	counts := map[string]int{}
+	for _, order := range noteOrders(3, 12) {
+		counts[fmt.Sprint(order)]++
+	}
+	if len(counts) != 6 {
+		t.Errorf("expected all 6 orders, got %v", counts)
+	}
+	for order, n := range counts {
+		if n != 2 {
+			t.Errorf("expected order %s twice, got %d", order, n)
+		}
+	}
+	// too many, so sampled
+	seen := map[string]bool{}
+	for _, order := range noteOrders(6, cellPatterns) {
+		if len(order) != 6 || seen[fmt.Sprint(order)] {
+			t.Errorf("expected a new order of 6 notes, got %v", order)
+		}
+		seen[fmt.Sprint(order)] = true
+	}
+}
+
+func TestCellSequence(t *testing.T) {
+	req := etudeRequest{pattern: "intervalcell", cell: "minor3-major2-perfect4-minor2-major2", tempo: "120"}
+	s := generateCellSequence(36, 84, 120, 0, req)
+	if len(s.seq) != cellPatterns {
+		t.Fatalf("expected %d patterns, got %d", cellPatterns, len(s.seq))
+	}
+	for i, ptn := range s.seq {
+		exp := patternFromIntervals(i%12, []int{3, 2, 5, 1, 2})
+		sorted := append(midiPattern{}, ptn...)
+		sort.Ints(sorted)
+		if diff := deep.Equal(sorted, exp); diff != nil {
+			t.Errorf("pattern %d: expected the pitches %v, got %v", i, exp, ptn)
+		}
+	}
+	// six notes take two bars for each repeat
+	req.repeats = 1
+	x := nBarsMusic(s.seq[0], &req).Bytes()
+	events := 0
+	for _, b := range x {
+		if b == 0x90 {
+			events++
+		}
+	}
+	if events != 12 {
+		t.Errorf("expected 12 notes, got %d", events)
+	}
+	if !reflect.DeepEqual(x[len(x)-2:], varLen(ticksPerBar+ticksPerBar-6*ticksPerBeat)) {
+		t.Errorf("expected the last repeat to end after two bars, got % x", x[len(x)-2:])
+	}
+	if got := etudeSeconds(&req, cellPatterns); got != float64((1+cellPatterns*2*2)*4*60)/120 {
+		t.Errorf("expected two bars per repeat, got %v seconds", got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/Michael-F-Ellis/infinite-etudes/internal/miditempo"
+)
+
+// playerNote is a note of an etude as played by the web page's Web Audio
+// player.
+type playerNote struct {
+	Time      float64 `json:"t"`    // seconds from the start of the etude
+	Duration  float64 `json:"d"`    // seconds
+	Pitch     float64 `json:"p"`    // midi pitch, with any retuning as a fraction
+	Amplitude float64 `json:"a"`    // 0 to 1, from velocity and channel volume
+	Channel   int     `json:"ch"`   // 0 to 15; 9 is percussion
+	Program   int     `json:"prog"` // General Midi program, 0 to 127
+}
+
+// playerEvents is the event list served by eventsHndlr.
+type playerEvents struct {
+	Starts []float64    `json:"starts"` // as from patternStartSeconds
+	Notes  []playerNote `json:"notes"`  // in time order
+}
+
+// defaultChannelVolume is the General Midi channel volume before any
+// channel volume controller.
+const defaultChannelVolume = 100
+
+// eventList returns the notes of midi, an etude made from seq and req, with
+// their times in seconds. Program changes, channel volumes, pitch bends and
+// MIDI Tuning Standard scale tunings are applied to the notes that follow
+// them. Notes with zero velocity, i.e. muted notes, are left out.
+func eventList(midi []byte, seq []midiPattern, req *etudeRequest) (list playerEvents, err error) {
+	tempos, err := miditempo.GetTempoMap(midi)
+	if err != nil {
+		return
+	}
+	events, err := miditempo.GetEvents(midi)
+	if err != nil {
+		return
+	}
+	if list.Starts, err = patternStartSeconds(midi, seq, req); err != nil {
+		return
+	}
+	var program [16]int
+	var bend [16]float64 // semitones
+	var volume [16]int
+	for i := range volume {
+		volume[i] = defaultChannelVolume
+	}
+	var tuned [16][12]float64 // cents by channel and pitch class, from MTS
+	sounding := map[int]int{} // index in list.Notes by channel<<8 | key
+	list.Notes = []playerNote{}
+	for _, e := range events {
+		d := e.Data
+		ch := int(d[0] & 0x0F)
+		switch {
+		case d[0]&0xF0 == 0x90 && d[2] > 0:
+			pitch := float64(d[1]) + bend[ch] + tuned[ch][d[1]%12]/100
+			sounding[ch<<8|int(d[1])] = len(list.Notes)
+			list.Notes = append(list.Notes, playerNote{
+				Time:      tickSeconds(tempos, e.Tick),
+				Pitch:     pitch,
+				Amplitude: float64(d[2]) / 127 * float64(volume[ch]) / 127,
+				Channel:   ch,
+				Program:   program[ch],
+			})
+		case d[0]&0xF0 == 0x80 || d[0]&0xF0 == 0x90:
+			i, ok := sounding[ch<<8|int(d[1])]
+			if !ok {
+				continue
+			}
+			delete(sounding, ch<<8|int(d[1]))
+			list.Notes[i].Duration = tickSeconds(tempos, e.Tick) - list.Notes[i].Time
+		case d[0]&0xF0 == 0xC0:
+			program[ch] = int(d[1])
+		case d[0]&0xF0 == 0xB0 && d[1] == 0x07:
+			volume[ch] = int(d[2])
+		case d[0]&0xF0 == 0xE0:
+			bend[ch] = float64((int(d[2])<<7|int(d[1]))-8192) / 8192 * 2 // the default 2 semitone range
+		case d[0] == 0xF0:
+			mtsTunings(d, &tuned)
+		}
+	}
+	return
+}
+
+// mtsTunings records in tuned the cents set for each channel and pitch
+// class by a MIDI Tuning Standard scale/octave tuning, as from
+// mtsScaleTuning, in the SysEx event sysex. Other SysEx events are ignored.
+func mtsTunings(sysex []byte, tuned *[16][12]float64) {
+	i := 1
+	for i < len(sysex) && sysex[i]&0x80 != 0 { // skip the length
+		i++
+	}
+	msg := sysex[i+1:]
+	if len(msg) < 19 || msg[0] != 0x7F || msg[2] != 0x08 || msg[3] != 0x08 {
+		return
+	}
+	mask := uint32(msg[4])<<14 | uint32(msg[5])<<7 | uint32(msg[6])
+	for ch := range tuned {
+		if mask&(1<<uint(ch)) == 0 {
+			continue
+		}
+		for pc := range tuned[ch] {
+			tuned[ch][pc] = float64(int(msg[7+pc]) - 0x40)
+		}
+	}
+}
+
+// eventsHndlr responds to /events/<seed> with the notes of the recently
+// served etude generated from <seed> as JSON for the web page's player. An
+// optional tempo query parameter sets the starting tempo as for
+// retempoHndlr. It gives a 404 if the etude is no longer in memory.
+func eventsHndlr(w http.ResponseWriter, r *http.Request) {
+	e, ok := pathEtude(w, r)
+	if !ok {
+		return
+	}
+	midi, ok := queryTempo(w, r, e)
+	if !ok {
+		return
+	}
+	list, err := eventList(midi, e.seq, &e.req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("could not list the events of etude %d: %v", e.seed, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(list); err != nil {
+		log.Printf("could not encode events of etude %d: %v", e.seed, err)
+	}
+}
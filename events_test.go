@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestEventList(t *testing.T) {
+	for _, mode := range []string{tuningModeBend, tuningModeMTS} {
+		s := etudeSequence{
+			seq:        []midiPattern{{60, 64, 61}, {60, 62, 64, 65, 67}},
+			tempo:      120,
+			instrument: 56,
+			req:        etudeRequest{repeats: 1, metroSound: defaultMetronomeSound, tuning: tuningJust, tuningMode: mode},
+		}
+		s.midi = midiBytes(&s)
+		list, err := eventList(s.midi, s.seq, &s.req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", mode, err)
+		}
+		if exp := []float64{2, 6, 14}; !reflect.DeepEqual(list.Starts, exp) {
+			t.Errorf("%s: expected starts %v, got %v", mode, exp, list.Starts)
+		}
+		var clicks int
+		var notes []playerNote
+		for _, n := range list.Notes {
+			if n.Channel == 9 {
+				clicks++
+				continue
+			}
+			notes = append(notes, n)
+		}
+		// a bar of count-in, then the metronome through six bars
+		if clicks != 28 {
+			t.Errorf("%s: expected 28 clicks, got %d", mode, clicks)
+		}
+		// each pattern played twice
+		if len(notes) != 16 {
+			t.Fatalf("%s: expected 16 notes, got %d: %v", mode, len(notes), notes)
+		}
+		first := notes[0]
+		if first.Time != 2 || first.Duration != 0.5 || first.Pitch != 60 || first.Program != 56 {
+			t.Errorf("%s: expected the tonic at 2 seconds for half a second, got %+v", mode, first)
+		}
+		// a just major third is 13.69 cents flat, a just minor second 11.73
+		// sharp, to the nearest cent with MTS
+		for i, exp := range []float64{63.8631, 61.1173} {
+			if got := notes[i+1].Pitch; math.Abs(got-exp) > 0.005 {
+				t.Errorf("%s: note %d: expected pitch %v, got %v", mode, i+1, exp, got)
+			}
+		}
+		if notes[6].Time != 6 || notes[6].Pitch != 60 {
+			t.Errorf("%s: expected the second pattern at 6 seconds, got %+v", mode, notes[6])
+		}
+	}
+}
+
+func TestEventsRequest(t *testing.T) {
+	resp, err := http.Get("http://" + testhost + "/etude/c/interval/minor3/minor2/minor2/trumpet/on/120/1/0")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	seed := resp.Header.Get("X-Etude-Seed")
+	resp, err = http.Get("http://" + testhost + "/events/" + seed + "?tempo=60")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	var list playerEvents
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("could not decode the event list: %v", err)
+	}
+	if len(list.Starts) != 13 || list.Starts[0] != 4 || list.Starts[12] != 100 {
+		t.Errorf("expected 12 patterns of 8 seconds after a 4 second count-in, got %v", list.Starts)
+	}
+	if len(list.Notes) == 0 || list.Notes[len(list.Notes)-1].Time >= 100 {
+		t.Errorf("expected notes within the etude, got %d", len(list.Notes))
+	}
+	for _, path := range []string{"/events/" + seed + "?tempo=5", "/events/x"} {
+		resp, err = http.Get("http://" + testhost + path)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %v, got %v", path, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
+func TestScaleTuningChannels(t *testing.T) {
+	// MTS retunes the etude's notes but not the drone on the tonal center
+	s := etudeSequence{
+		seq:   []midiPattern{{62, 66, 69}},
+		tempo: 120,
+		req: etudeRequest{pattern: "allintervals", tonalCenter: "d", repeats: 1, metroSound: defaultMetronomeSound,
+			tuning: tuningJust, tuningMode: tuningModeMTS, drone: "fifth", droneSound: defaultDroneSound, droneVolume: 80},
+	}
+	s.midi = midiBytes(&s)
+	list, err := eventList(s.midi, s.seq, &s.req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var drones int
+	for _, n := range list.Notes {
+		switch n.Channel {
+		case 0:
+			if n.Pitch == 66 {
+				t.Errorf("expected the third to be retuned, got %v", n.Pitch)
+			}
+		case 1:
+			drones++
+			if n.Pitch != math.Round(n.Pitch) {
+				t.Errorf("expected the drone in equal temperament, got %v", n.Pitch)
+			}
+		}
+	}
+	if drones != 2 {
+		t.Errorf("expected a drone on the root and fifth, got %d notes", drones)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// fretboard describes the strings and frets of a fretted instrument.
+type fretboard struct {
+	open  []int    // midi pitches of the open strings, lowest first
+	names []string // string names for tablature, lowest first
+	frets int      // highest fret
+}
+
+// Fretboards for the guitars and basses in supportedInstruments.
+var (
+	guitar12 = &fretboard{open: []int{40, 45, 50, 55, 59, 64}, names: []string{"E", "A", "D", "G", "B", "e"}, frets: 12}
+	guitar24 = &fretboard{open: []int{40, 45, 50, 55, 59, 64}, names: []string{"E", "A", "D", "G", "B", "e"}, frets: 24}
+	bass12   = &fretboard{open: []int{28, 33, 38, 43}, names: []string{"E", "A", "D", "G"}, frets: 12}
+	bass24   = &fretboard{open: []int{28, 33, 38, 43}, names: []string{"E", "A", "D", "G"}, frets: 24}
+)
+
+// positionFrets is the number of frets a hand covers in one position: one
+// finger per fret plus a stretch back with the index finger.
+const positionFrets = 5
+
+// fretNote is a note played on a string at a fret. Strings are numbered as
+// guitarists do, from 1 for the highest.
+type fretNote struct {
+	str, fret int
+}
+
+// stringNumbers returns the strings in set, a string of digits like "123",
+// or all the strings of b if set is empty.
+func (b *fretboard) stringNumbers(set string) (strs []int, err error) {
+	if set == "" {
+		for i := range b.open {
+			strs = append(strs, i+1)
+		}
+		return
+	}
+	seen := map[int]bool{}
+	for _, c := range set {
+		n := int(c - '0')
+		if n < 1 || n > len(b.open) {
+			return nil, fmt.Errorf("%q is not a string number from 1 to %d", c, len(b.open))
+		}
+		if seen[n] {
+			return nil, fmt.Errorf("string %d appears more than once in %q", n, set)
+		}
+		seen[n] = true
+		strs = append(strs, n)
+	}
+	if len(strs) < 2 {
+		return nil, fmt.Errorf("string set %q needs at least two strings", set)
+	}
+	return
+}
+
+// fingerings returns the ways to play each pitch on the strings in set at
+// the frets of position, or anywhere on the neck if position is 0. Position
+// n covers frets n-1 to n+3, so the first position includes the open
+// strings.
+func (b *fretboard) fingerings(position int, set string) (f map[int][]fretNote, err error) {
+	strs, err := b.stringNumbers(set)
+	if err != nil {
+		return
+	}
+	lo, hi := 0, b.frets
+	if position != 0 {
+		if position < 1 || position+positionFrets-2 > b.frets {
+			return nil, fmt.Errorf("position %d is not between 1 and %d", position, b.frets-positionFrets+2)
+		}
+		lo, hi = position-1, position+positionFrets-2
+	}
+	f = map[int][]fretNote{}
+	for _, s := range strs {
+		open := b.open[len(b.open)-s]
+		for fret := lo; fret <= hi; fret++ {
+			f[open+fret] = append(f[open+fret], fretNote{s, fret})
+		}
+	}
+	return
+}
+
+// requestFingerings returns the fingerings allowed by the position and
+// string set of req, or nil if req doesn't restrict them.
+func requestFingerings(req *etudeRequest) map[int][]fretNote {
+	if req.position == 0 && req.stringSet == "" {
+		return nil
+	}
+	iInfo, err := getSupportedInstrumentByName(req.instrument)
+	if err != nil || iInfo.fretboard == nil {
+		return nil
+	}
+	f, err := iInfo.fretboard.fingerings(req.position, req.stringSet)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// validFretting returns true if req doesn't ask for a position or string
+// set, or asks for one that its instrument has and that can finger the
+// patterns of req in the instrument's range, or in each register req
+// targets. Every transposition of a pattern fits in a run of fingered
+// pitches an octave wider than the pattern. All intervals patterns lie
+// within an octave. The span of patterns not made from named intervals
+// isn't known, so those need only every pitch class.
+func validFretting(req etudeRequest) bool {
+	if req.position == 0 && req.stringSet == "" {
+		return true
+	}
+	iInfo, err := getSupportedInstrumentByName(req.instrument)
+	if err != nil || iInfo.fretboard == nil {
+		return false
+	}
+	f, err := iInfo.fretboard.fingerings(req.position, req.stringSet)
+	if err != nil {
+		return false
+	}
+	span := 11
+	if req.pattern != "allintervals" {
+		span = intervalSpan(requestIntervals(&req))
+	}
+	need := span + 12
+	bands := [][2]int{{iInfo.midilo, iInfo.midihi}}
+	if req.register != "" {
+		bands = nil
+		for _, reg := range patternRegisters(req.register, len(registerSweep)) {
+			lo, hi := registerBand(reg, iInfo.midilo, iInfo.midihi)
+			bands = append(bands, [2]int{lo, hi})
+		}
+	}
+	for _, band := range bands {
+		if fingeredRun(f, band[0], band[1]) < need {
+			return false
+		}
+	}
+	return true
+}
+
+// fingeredRun returns the length of the longest run of consecutive pitches
+// from lo to hi that all have fingerings.
+func fingeredRun(fingerings map[int][]fretNote, lo, hi int) (longest int) {
+	run := 0
+	for p := lo; p <= hi; p++ {
+		if len(fingerings[p]) == 0 {
+			run = 0
+			continue
+		}
+		run++
+		if run > longest {
+			longest = run
+		}
+	}
+	return
+}
+
+// fitFingerings shifts t by octaves so that all of its notes lie between lo
+// and hi and can be played with fingerings, choosing the shift that keeps
+// the first note nearest prior. It returns an error, leaving t as placed,
+// if no shift does.
+func fitFingerings(t *midiPattern, prior, lo, hi int, fingerings map[int][]fretNote) error {
+	best, bestDist := 0, -1
+	for shift := -120; shift <= 120; shift += 12 {
+		fits := true
+		for _, p := range *t {
+			if p+shift < lo || p+shift > hi || len(fingerings[p+shift]) == 0 {
+				fits = false
+				break
+			}
+		}
+		if !fits {
+			continue
+		}
+		dist := (*t)[0] + shift - prior
+		if dist < 0 {
+			dist = -dist
+		}
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = shift, dist
+		}
+	}
+	if bestDist < 0 {
+		return fmt.Errorf("no fingering of %v between %d and %d", *t, lo, hi)
+	}
+	for i := range *t {
+		(*t)[i] += best
+	}
+	return nil
+}
+
+// tablature returns plain text tablature for the patterns in seq, one staff
+// per pattern. Each note uses the fingering allowed by req nearest the fret
+// of the note before, or any fingering on the neck if none is allowed.
+func tablature(b *fretboard, seq []midiPattern, req *etudeRequest) string {
+	allowed := requestFingerings(req)
+	anywhere, _ := b.fingerings(0, "")
+	var sb strings.Builder
+	for i, ptn := range seq {
+		lines := make([]strings.Builder, len(b.open))
+		for s := range lines {
+			lines[s].WriteString(b.names[len(b.open)-1-s] + "|-")
+		}
+		prev := -1
+		for _, p := range ptn {
+			choices := allowed[p]
+			if len(choices) == 0 {
+				choices = anywhere[p]
+			}
+			col := "--"
+			var note fretNote
+			if len(choices) > 0 {
+				note = nearestFret(choices, prev)
+				prev = note.fret
+				col = strings.Replace(fmt.Sprintf("%-2d", note.fret), " ", "-", 1)
+			}
+			for s := range lines {
+				if note.str == s+1 {
+					lines[s].WriteString(col + "-")
+				} else {
+					lines[s].WriteString("---")
+				}
+			}
+		}
+		fmt.Fprintf(&sb, "Pattern %d\n", i+1)
+		for s := range lines {
+			sb.WriteString(lines[s].String() + "|\n")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// nearestFret returns the choice whose fret is nearest prev, or the lowest
+// fret if prev is negative.
+func nearestFret(choices []fretNote, prev int) (best fretNote) {
+	for i, c := range choices {
+		d, bd := c.fret-prev, best.fret-prev
+		if prev < 0 {
+			d, bd = c.fret, best.fret
+		}
+		if d < 0 {
+			d = -d
+		}
+		if bd < 0 {
+			bd = -bd
+		}
+		if i == 0 || d < bd {
+			best = c
+		}
+	}
+	return
+}
+
+// tabHndlr responds to /tab/<seed> with plain text tablature for the
+// recently served guitar or bass etude generated from <seed>. It gives a
+// 404 if the etude is no longer in memory or isn't for a fretted
+// instrument.
+func tabHndlr(w http.ResponseWriter, r *http.Request) {
+	e, ok := pathEtude(w, r)
+	if !ok {
+		return
+	}
+	iInfo, err := getSupportedInstrumentByName(e.req.instrument)
+	if err != nil || iInfo.fretboard == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err = io.WriteString(w, tablature(iInfo.fretboard, e.seq, &e.req)); err != nil {
+		log.Printf("could not write tablature of etude %d: %v", e.seed, err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFingerings(t *testing.T) {
+	f, err := guitar12.fingerings(5, "12")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// frets 4 to 8 on the B and high E strings
+	for p := 63; p <= 72; p++ {
+		if len(f[p]) == 0 {
+			t.Errorf("expected a fingering for %d", p)
+		}
+	}
+	if len(f[62]) != 0 || len(f[73]) != 0 {
+		t.Errorf("expected only pitches 63 to 72, got %v", f)
+	}
+	if len(f[64]) != 1 || f[64][0] != (fretNote{2, 5}) {
+		t.Errorf("expected E4 only at the fifth fret of the B string, got %v", f[64])
+	}
+	for _, bad := range []struct {
+		position int
+		set      string
+	}{{10, ""}, {-1, ""}, {0, "7"}, {0, "1"}, {0, "11"}, {0, "1x"}} {
+		if _, err := guitar12.fingerings(bad.position, bad.set); err == nil {
+			t.Errorf("expected an error for position %d, strings %q", bad.position, bad.set)
+		}
+	}
+	if _, err := bass24.fingerings(21, "34"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFitFingerings(t *testing.T) {
+	f, _ := guitar12.fingerings(1, "")
+	ptn := midiPattern{72, 76, 79} // above the first position
+	if err := fitFingerings(&ptn, 72, 40, 76, f); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if exp := (midiPattern{60, 64, 67}); ptn[0] != exp[0] || ptn[1] != exp[1] || ptn[2] != exp[2] {
+		t.Errorf("expected %v, got %v", exp, ptn)
+	}
+	// the first position ends at 68, so nothing fits from 64 up
+	ptn = midiPattern{72, 76, 79}
+	if err := fitFingerings(&ptn, 72, 64, 76, f); err == nil {
+		t.Errorf("expected an error, got %v", ptn)
+	}
+	if ptn[0] != 72 {
+		t.Errorf("expected the pattern left as placed, got %v", ptn)
+	}
+}
+
+func TestValidFretting(t *testing.T) {
+	for _, c := range []struct {
+		req etudeRequest
+		exp bool
+	}{
+		{etudeRequest{pattern: "interval", interval1: "minor3", instrument: "electric_bass_finger"}, true},
+		{etudeRequest{pattern: "interval", interval1: "minor3", instrument: "electric_bass_finger", position: 3, stringSet: "234"}, true},
+		// frets 2 to 6 of the low strings cover only 30 to 39
+		{etudeRequest{pattern: "interval", interval1: "minor3", instrument: "electric_bass_finger", position: 3, stringSet: "34"}, false},
+		{etudeRequest{pattern: "interval", interval1: "major3", instrument: "acoustic_guitar_steel", position: 5}, true},
+		{etudeRequest{pattern: "interval", interval1: "octave", instrument: "acoustic_guitar_steel", position: 5, stringSet: "12"}, false},
+		{etudeRequest{pattern: "allintervals", instrument: "acoustic_guitar_steel", position: 5, stringSet: "12"}, false},
+		// the low third of the guitar, 40 to 51, is below most of the fifth position
+		{etudeRequest{pattern: "interval", interval1: "major3", instrument: "acoustic_guitar_steel", position: 5, register: registerLow}, false},
+		{etudeRequest{pattern: "interval", interval1: "major3", instrument: "trumpet", position: 5}, false},
+	} {
+		if got := validFretting(c.req); got != c.exp {
+			t.Errorf("%+v: expected %v, got %v", c.req, c.exp, got)
+		}
+	}
+}
+
+func TestFrettedPlacement(t *testing.T) {
+	seedEtudeRandom(1)
+	req := etudeRequest{pattern: "interval", interval1: "major3", instrument: "acoustic_guitar_steel", position: 5}
+	s := generateEqualIntervalSequence(40, 76, 120, 25, req)
+	mkMidi(&s, true)
+	os.Remove(s.filename)
+	f := requestFingerings(&req)
+	for _, ptn := range s.seq {
+		for _, p := range ptn {
+			if len(f[p]) == 0 {
+				t.Errorf("%v can't be played in the fifth position", ptn)
+				break
+			}
+		}
+	}
+}
+
+func TestTablature(t *testing.T) {
+	req := etudeRequest{instrument: "acoustic_guitar_steel", position: 5}
+	tab := tablature(guitar12, []midiPattern{{52, 55, 64}, {40}}, &req)
+	// E2 is out of position, so it's played on the open string
+	exp := `Pattern 1
+e|----------|
+B|-------5--|
+G|----------|
+D|----5-----|
+A|-7--------|
+E|----------|
+
+Pattern 2
+e|----|
+B|----|
+G|----|
+D|----|
+A|----|
+E|-0--|
+
+`
+	if tab != exp {
+		t.Errorf("expected\n%s\ngot\n%s", exp, tab)
+	}
+}
+
+func TestTabRequest(t *testing.T) {
+	resp, err := http.Get("http://" + testhost + "/etude/c/interval/minor3/minor2/minor2/electric_bass_finger/on/120/1/0?position=3&strings=234")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	resp, err = http.Get("http://" + testhost + "/tab/" + resp.Header.Get("X-Etude-Seed"))
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "Pattern 1\nG|-") {
+		t.Errorf("expected tablature, got %d: %s", resp.StatusCode, body)
+	}
+	resp, err = http.Get("http://" + testhost + "/etude/c/interval/minor3/minor2/minor2/electric_bass_finger/on/120/1/0?position=3&strings=34")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code %v for strings too few to finger the etude, got %v", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp, err = http.Get("http://" + testhost + "/etude/c/interval/minor3/minor2/minor2/trumpet/on/120/1/0?position=3")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code %v for a trumpet position, got %v", http.StatusBadRequest, resp.StatusCode)
+	}
+}
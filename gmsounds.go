@@ -172,3 +172,43 @@ func gmSoundFileNamePrefix(name string) string {
 	clean = strings.Replace(clean, " ", "_", -1)
 	return clean
 }
+
+// metronomeSound is a pair of General Midi percussion keys (channel 10) for
+// metronome clicks.
+type metronomeSound struct {
+	name   string // used in etude URLs and file names
+	uiName string // what we show in the UI
+	accent byte   // key for downbeats
+	other  byte   // key for other beats and subdivisions
+}
+
+// defaultMetronomeSound is the name of the sound used when a request
+// doesn't specify one.
+const defaultMetronomeSound = "woodblock"
+
+// metronomeSounds are the supported metronome sounds. The first is the
+// default.
+var metronomeSounds = []metronomeSound{
+	{defaultMetronomeSound, "Wood Block", 76, 77},
+	{"claves", "Claves", 75, 75},
+	{"sidestick", "Side Stick", 37, 37},
+	{"cowbell", "Cowbell", 56, 56},
+	{"hihat", "Hi-Hat", 46, 42},
+	{"bongo", "Bongos", 60, 61},
+	{"triangle", "Triangle", 81, 80},
+}
+
+// metronomeSoundByName returns the metronome sound with the given name or
+// the default sound if name is empty. It returns false if name isn't
+// supported.
+func metronomeSoundByName(name string) (sound metronomeSound, ok bool) {
+	if name == "" {
+		return metronomeSounds[0], true
+	}
+	for _, sound = range metronomeSounds {
+		if sound.name == name {
+			return sound, true
+		}
+	}
+	return
+}
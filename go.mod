@@ -6,5 +6,6 @@ require (
 	github.com/Michael-F-Ellis/goht v1.1.1
 	github.com/go-test/deep v1.0.7
 	github.com/sergi/go-diff v1.1.0 // indirect
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/net v0.0.0-20201024042810-be3efd7ff127 // indirect
 )
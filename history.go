@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// practiceHistory is an embedded database of user profiles and the etudes
+// each user has requested.
+type practiceHistory struct {
+	db *bolt.DB
+}
+
+// history is the database used by the server. It is nil when history
+// recording is disabled.
+var history *practiceHistory
+
+// historyPath is the file name of the history database. An empty string
+// disables history recording.
+var historyPath string
+
+var (
+	usersBucket   = []byte("users")
+	historyBucket = []byte("history")
+)
+
+// userProfile is stored in the users bucket under the user's name.
+type userProfile struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+// practiceRecord describes one etude served to a user.
+type practiceRecord struct {
+	Time        time.Time `json:"time"`
+	Pattern     string    `json:"pattern"`
+	TonalCenter string    `json:"tonalCenter,omitempty"`
+	Intervals   []string  `json:"intervals,omitempty"`
+	Instrument  string    `json:"instrument"`
+	Metronome   string    `json:"metronome"`
+	Tempo       int       `json:"tempo"`
+	Repeats     int       `json:"repeats"`
+	Silent      int       `json:"silent"`
+	Mute        string    `json:"mute,omitempty"`
+	Seed        int64     `json:"seed"`
+	Filename    string    `json:"filename"`
+	Seconds     float64   `json:"seconds"` // playing time of the etude
+}
+
+// historySummary is the response body for a user's history request.
+type historySummary struct {
+	User               string             `json:"user"`
+	Etudes             int                `json:"etudes"`
+	TotalSeconds       float64            `json:"totalSeconds"`
+	Streak             int                `json:"streak"`        // consecutive days ending today or yesterday
+	LongestStreak      int                `json:"longestStreak"` // longest run of consecutive days
+	SecondsPerInterval map[string]float64 `json:"secondsPerInterval"`
+	Records            []practiceRecord   `json:"records"`
+}
+
+// openHistory opens (creating if needed) the history database at path.
+func openHistory(path string) (h *practiceHistory, err error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		err = fmt.Errorf("could not open history database %s: %v", path, err)
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{usersBucket, historyBucket, cardsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return
+	}
+	h = &practiceHistory{db: db}
+	return
+}
+
+// Close closes the underlying database.
+func (h *practiceHistory) Close() error {
+	return h.db.Close()
+}
+
+var userNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+
+// validUserName returns true if name is acceptable as a user name.
+func validUserName(name string) bool {
+	return userNameRegexp.MatchString(name)
+}
+
+// timeKey returns a database key that sorts in time order.
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// record appends rec to the user's history, creating the user's profile
+// if it doesn't exist yet.
+func (h *practiceHistory) record(user string, rec practiceRecord) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(usersBucket)
+		if users.Get([]byte(user)) == nil {
+			profile, err := json.Marshal(userProfile{Name: user, Created: rec.Time})
+			if err != nil {
+				return err
+			}
+			if err = users.Put([]byte(user), profile); err != nil {
+				return err
+			}
+		}
+		b, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return b.Put(timeKey(rec.Time), value)
+	})
+}
+
+// records returns the user's practice history in time order. It returns a
+// non-nil error if the user has no profile.
+func (h *practiceHistory) records(user string) (recs []practiceRecord, err error) {
+	err = h.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(usersBucket).Get([]byte(user)) == nil {
+			return fmt.Errorf("no such user: %s", user)
+		}
+		b := tx.Bucket(historyBucket).Bucket([]byte(user))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var rec practiceRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			recs = append(recs, rec)
+			return nil
+		})
+	})
+	return
+}
+
+// summarize computes totals and streaks for user from recs. Days are
+// counted in now's location.
+func summarize(user string, recs []practiceRecord, now time.Time) (s historySummary) {
+	s.User = user
+	s.Etudes = len(recs)
+	s.Records = recs
+	s.SecondsPerInterval = map[string]float64{}
+	days := map[string]bool{}
+	for _, rec := range recs {
+		s.TotalSeconds += rec.Seconds
+		for _, name := range rec.Intervals {
+			s.SecondsPerInterval[name] += rec.Seconds / float64(len(rec.Intervals))
+		}
+		days[rec.Time.In(now.Location()).Format("2006-01-02")] = true
+	}
+	// longest run of consecutive practice days
+	var sorted []string
+	for d := range days {
+		sorted = append(sorted, d)
+	}
+	sort.Strings(sorted)
+	run := 0
+	var prev time.Time
+	for _, d := range sorted {
+		t, _ := time.ParseInLocation("2006-01-02", d, now.Location())
+		if run > 0 && prev.AddDate(0, 0, 1).Equal(t) {
+			run++
+		} else {
+			run = 1
+		}
+		if run > s.LongestStreak {
+			s.LongestStreak = run
+		}
+		prev = t
+	}
+	// current streak may end today or yesterday
+	day := now
+	if !days[day.Format("2006-01-02")] {
+		day = day.AddDate(0, 0, -1)
+	}
+	for days[day.Format("2006-01-02")] {
+		s.Streak++
+		day = day.AddDate(0, 0, -1)
+	}
+	return
+}
+
+// requestIntervals returns the names of the intervals practiced in an etude
+// made from req.
+func requestIntervals(req *etudeRequest) (names []string) {
+	switch req.pattern {
+	case "interval":
+		names = []string{req.interval1}
+	case "intervalpair":
+		names = []string{req.interval1, req.interval2}
+	case "intervaltriple":
+		names = []string{req.interval1, req.interval2, req.interval3}
+	case "intervalcell":
+		names = cellIntervalNames(req)
+	case "allintervals":
+		for _, inf := range intervalInfo {
+			if inf.size <= 12 { // no compound intervals
+				names = append(names, inf.fileName)
+			}
+		}
+	}
+	return
+}
+
+// etudeSeconds returns the playing time in seconds of an etude with
+// npatterns patterns made from req, including the count-in.
+func etudeSeconds(req *etudeRequest, npatterns int) float64 {
+	tempo, err := strconv.Atoi(req.tempo)
+	if err != nil || tempo < 1 {
+		return 0
+	}
+	passBars := 1 // bars for each repeat of a pattern
+	switch req.pattern {
+	case "intervalcell":
+		passBars = cellBars(len(cellIntervalNames(req)) + 1)
+	case "melody":
+		passBars = cellBars(melodyNotes)
+	case "setclass":
+		prime, _ := parseSetClass(req.setClass)
+		passBars = cellBars(len(prime))
+	case "tonerow":
+		passBars = cellBars(12)
+	}
+	bars := countInBars(req) + npatterns*(1+req.repeats)*passBars
+	return float64(bars*4*60) / float64(tempo)
+}
+
+// newPracticeRecord returns a record of an etude made from req.
+func newPracticeRecord(req *etudeRequest, etude generatedEtude, filename string, t time.Time) practiceRecord {
+	tempo, _ := strconv.Atoi(req.tempo)
+	rec := practiceRecord{
+		Time:       t,
+		Pattern:    req.pattern,
+		Intervals:  requestIntervals(req),
+		Instrument: req.instrument,
+		Metronome:  metronomeSegment(req),
+		Tempo:      tempo,
+		Repeats:    req.repeats,
+		Silent:     req.silent,
+		Mute:       req.mute,
+		Seed:       etude.seed,
+		Filename:   filename,
+		Seconds:    etudeSeconds(req, etude.patterns),
+	}
+	if req.pattern == "allintervals" {
+		rec.TonalCenter = req.tonalCenter
+	}
+	return rec
+}
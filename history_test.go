@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Michael-F-Ellis/goht"
+)
+
+func TestPracticeHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	h, err := openHistory(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if _, err = h.records("nobody"); err == nil {
+		t.Errorf("expected an error for a user with no profile")
+	}
+	req := etudeRequest{pattern: "intervalpair", interval1: "minor3", interval2: "major3", instrument: "viola", tempo: "120", repeats: 3}
+	t0 := time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		rec := newPracticeRecord(&req, generatedEtude{seed: int64(i), patterns: 12}, req.midiFilename(), t0.Add(time.Duration(i)*time.Minute))
+		if err = h.record("mike", rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recs, err := h.records("mike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(recs))
+	}
+	for i, rec := range recs {
+		if rec.Seed != int64(i) {
+			t.Errorf("expected records in time order, got seed %d at %d", rec.Seed, i)
+		}
+	}
+	// 1 count-in bar + 12 patterns * 4 bars = 49 bars of 4 beats at 120 bpm
+	if recs[0].Seconds != 98 {
+		t.Errorf("expected 98 seconds, got %v", recs[0].Seconds)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 11, d, 12, 0, 0, 0, time.UTC) }
+	recs := []practiceRecord{
+		{Time: day(1), Intervals: []string{"minor3", "major3"}, Seconds: 100},
+		{Time: day(2), Intervals: []string{"minor3"}, Seconds: 50},
+		{Time: day(3), Intervals: []string{"minor3"}, Seconds: 50},
+		{Time: day(6), Intervals: []string{"octave"}, Seconds: 10},
+		{Time: day(7), Intervals: []string{"octave"}, Seconds: 10},
+	}
+	s := summarize("mike", recs, day(8))
+	if s.Etudes != 5 || s.TotalSeconds != 220 {
+		t.Errorf("expected 5 etudes and 220 seconds, got %d and %v", s.Etudes, s.TotalSeconds)
+	}
+	if s.Streak != 2 {
+		t.Errorf("expected current streak of 2, got %d", s.Streak)
+	}
+	if s.LongestStreak != 3 {
+		t.Errorf("expected longest streak of 3, got %d", s.LongestStreak)
+	}
+	if s.SecondsPerInterval["minor3"] != 150 || s.SecondsPerInterval["major3"] != 50 {
+		t.Errorf("unexpected seconds per interval %v", s.SecondsPerInterval)
+	}
+	s = summarize("mike", recs, day(10))
+	if s.Streak != 0 {
+		t.Errorf("expected streak to be broken, got %d", s.Streak)
+	}
+}
+
+func TestIndexHistoryControls(t *testing.T) {
+	saved := history
+	defer func() { history = saved }()
+	for _, tc := range []struct {
+		history *practiceHistory
+		want    bool
+	}{
+		{&practiceHistory{}, true},
+		{nil, false},
+	} {
+		history = tc.history
+		var buf bytes.Buffer
+		if err := goht.Render(indexBody(), &buf, 0); err != nil {
+			t.Fatal(err)
+		}
+		page := buf.String()
+		for _, s := range []string{`id="user-input"`, `showHistory()"`, `rateEtude()"`, `value="adaptive"`} {
+			if got := strings.Contains(page, s); got != tc.want {
+				t.Errorf("history %v: page has %s: %v", tc.want, s, got)
+			}
+		}
+		if got := strings.Contains(page, `id="history-off"`); got == tc.want {
+			t.Errorf("history %v: page has history-off message: %v", tc.want, got)
+		}
+	}
+}
@@ -16,10 +16,25 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"math/rand"
-	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
+// rng is the source of randomness for etude generation. Replace it with
+// seedEtudeRandom to make generation repeatable.
+var rng = rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
+
+// seedEtudeRandom replaces rng with a new source initialized from seed so
+// that an etude generated afterward can be regenerated exactly from the same
+// seed and request. Callers must hold etudeMutex.
+func seedEtudeRandom(seed int64) {
+	rng = rand.New(rand.NewSource(seed))
+}
+
 type midiPattern []int
 
 type etudeSequence struct {
@@ -31,6 +46,9 @@ type etudeSequence struct {
 	keyname    string
 	filename   string
 	req        etudeRequest
+	midi       []byte   // content of the midi file
+	chords     [][]int  // pitch classes of the backing chord for each pattern, if any
+	cards      []string // review card keys drilled by an adaptive etude
 }
 
 var keyNames = []string{"c", "dflat", "d", "eflat", "e", "f", "gflat", "g", "aflat", "a", "bflat", "b"}
@@ -65,20 +83,25 @@ func getChromaticScale() (scale []int) {
 // of intervals, i1 and i2, (measured in half steps). The construction always
 // ascends from p.
 func tripleFrom2Intervals(p, i1, i2 int) (t midiPattern) {
-	q := p + i1
-	r := q + i2
-	t = midiPattern{p, q, r}
-	return
+	return patternFromIntervals(p, []int{i1, i2})
 }
 
 // quadFrom3Intervals builds a 4 element midiPattern from an initial pitch, p, and three
 // intervals, i1, i2, i3, (measured in half steps). The construction always
 // ascends from p.
 func quadFrom3Intervals(p, i1, i2, i3 int) (t midiPattern) {
-	q := p + i1
-	r := q + i2
-	s := r + i3
-	t = midiPattern{p, q, r, s}
+	return patternFromIntervals(p, []int{i1, i2, i3})
+}
+
+// patternFromIntervals builds a midiPattern of len(intervals)+1 notes from an
+// initial pitch, p, and a list of intervals (measured in half steps). The
+// construction always ascends from p.
+func patternFromIntervals(p int, intervals []int) (t midiPattern) {
+	t = midiPattern{p}
+	for _, i := range intervals {
+		p += i
+		t = append(t, p)
+	}
 	return
 }
 
@@ -110,7 +133,7 @@ func tripleFromPitchPair(p, q int, up bool) (t midiPattern) {
 
 // flip simulates a fair coin flip
 func flip() (up bool) {
-	if rand.Intn(2) == 1 {
+	if rng.Intn(2) == 1 {
 		up = true
 	}
 	return
@@ -177,6 +200,199 @@ func permute4(scale []int) []midiPattern {
 
 }
 
+// Melodic directions for etudeRequest.direction. Any other non-empty
+// direction is a contour, a string with one u (up) or d (down) for each
+// step between the notes of a pattern, e.g. "udu" for a quad. An empty
+// direction, "mixed", keeps the random note orders.
+const (
+	directionAscending  = "ascending"
+	directionDescending = "descending"
+	directionMixed      = "mixed"
+)
+
+// patternContour returns the contour, as described above, that direction
+// asks of a pattern with n notes.
+func patternContour(direction string, n int) string {
+	switch direction {
+	case directionAscending:
+		return strings.Repeat("u", n-1)
+	case directionDescending:
+		return strings.Repeat("d", n-1)
+	}
+	return direction
+}
+
+// validDirection returns true if direction is empty, "mixed", "ascending",
+// "descending" or a contour that fits the patterns of req.
+func validDirection(req etudeRequest) bool {
+	var n int
+	switch req.pattern {
+	case "interval", "intervalpair":
+		n = 3
+	case "intervaltriple":
+		n = 4
+	case "intervalcell":
+		n = len(cellIntervalNames(&req)) + 1
+	default:
+		return req.direction == ""
+	}
+	switch req.direction {
+	case "", directionMixed, directionAscending, directionDescending:
+		return true
+	}
+	if len(req.direction) != n-1 || strings.Trim(req.direction, "ud") != "" {
+		return false
+	}
+	if req.pattern == "interval" {
+		// the pattern returns to its first note
+		return req.direction == "ud" || req.direction == "du"
+	}
+	return true
+}
+
+// matchesContour returns true if each step between the pitches of t moves
+// in the direction given by the corresponding letter of contour. Repeated
+// pitches match either direction.
+func matchesContour(t midiPattern, contour string) bool {
+	for i := 1; i < len(t); i++ {
+		switch {
+		case contour[i-1] == 'u' && t[i] < t[i-1]:
+			return false
+		case contour[i-1] == 'd' && t[i] > t[i-1]:
+			return false
+		}
+	}
+	return true
+}
+
+// orientPatterns rearranges the patterns of an interval, intervalpair or
+// intervaltriple sequence to follow req.direction. Interval patterns, which
+// return to their first note, move the middle note an octave if needed.
+// Other patterns are put in a note order chosen at random from the orders
+// that match the contour.
+func orientPatterns(sequence *etudeSequence) {
+	req := &sequence.req
+	if req.direction == "" || req.direction == directionMixed {
+		return
+	}
+	var orders []midiPattern // note orders for patterns the length of t
+	for i, t := range sequence.seq {
+		contour := patternContour(req.direction, len(t))
+		if req.pattern == "interval" {
+			if d := t[1] - t[0]; (contour[0] == 'u') != (d >= 0) {
+				t[1] = t[0] - d
+			}
+			continue
+		}
+		if len(orders) == 0 || len(orders[0]) != len(t) {
+			orders = permuteIndices(len(t))
+		}
+		var matches []midiPattern
+		for _, idx := range orders {
+			ptn := make(midiPattern, len(t))
+			for j := range t {
+				ptn[j] = t[idx[j]]
+			}
+			if matchesContour(ptn, contour) {
+				matches = append(matches, ptn)
+			}
+		}
+		sequence.seq[i] = matches[rng.Intn(len(matches))]
+	}
+}
+
+// permuteIndices returns all orderings of the indices 0 to n-1.
+func permuteIndices(n int) (orders []midiPattern) {
+	if n == 0 {
+		return []midiPattern{{}}
+	}
+	for _, o := range permuteIndices(n - 1) {
+		// insert n-1 at each position of the shorter orderings
+		for i := 0; i <= len(o); i++ {
+			order := make(midiPattern, 0, n)
+			order = append(order, o[:i]...)
+			order = append(order, n-1)
+			order = append(order, o[i:]...)
+			orders = append(orders, order)
+		}
+	}
+	return
+}
+
+// noteOrders returns count orderings of the indices of a pattern with n
+// notes. If there are no more than count possible orderings, each of them
+// appears equally often (as nearly as count allows) in random order.
+// Otherwise there are too many to enumerate and noteOrders returns count
+// different orderings chosen at random.
+func noteOrders(n, count int) (orders []midiPattern) {
+	possible := 1
+	for i := 2; i <= n && possible <= count; i++ {
+		possible *= i
+	}
+	if possible <= count {
+		all := permuteIndices(n)
+		for len(orders) < count {
+			shufflePatterns(all)
+			orders = append(orders, all...)
+		}
+		return orders[:count]
+	}
+	chosen := map[string]bool{}
+	for len(orders) < count {
+		order := make(midiPattern, n)
+		for i := range order {
+			order[i] = i
+		}
+		shufflePatternPitches(&order)
+		key := fmt.Sprint(order)
+		if chosen[key] {
+			continue
+		}
+		chosen[key] = true
+		orders = append(orders, order)
+	}
+	return
+}
+
+// cellPatterns is the number of patterns in an Interval Cell etude, two
+// beginning on each pitch of the chromatic scale.
+const cellPatterns = 24
+
+// maxCellNotes limits the length of Interval Cell patterns to two bars.
+const maxCellNotes = 8
+
+// cellBars returns the number of bars needed to play a pattern of n notes,
+// one per beat. Patterns longer than a bar continue into the next.
+func cellBars(n int) int {
+	return (n + 3) / 4
+}
+
+// generateCellSequence returns an etudeSequence of cellPatterns patterns
+// built from the intervals in req.cell, each in a different note order.
+func generateCellSequence(midilo int, midihi int, tempo int, instrument int, req etudeRequest) (sequence etudeSequence) {
+	var intervals []int
+	for _, name := range cellIntervalNames(&req) {
+		intervals = append(intervals, intervalSizeByName(name))
+	}
+	sequence = etudeSequence{
+		midilo:     midilo,
+		midihi:     midihi,
+		tempo:      tempo,
+		instrument: instrument,
+		req:        req,
+	}
+	// rearrange the pattern pitches using the list of note orders
+	for i, idx := range noteOrders(len(intervals)+1, cellPatterns) {
+		t := patternFromIntervals(i%12, intervals)
+		ptn := make(midiPattern, len(t))
+		for j := range t {
+			ptn[j] = t[idx[j]]
+		}
+		sequence.seq = append(sequence.seq, ptn)
+	}
+	return
+}
+
 // generateEqualIntervalSequence returns a slice of etudeSequences as described in the usage instructions.
 // Each sequence consists of triples of equal interval sizes
 func generateEqualIntervalSequence(midilo int, midihi int, tempo int, instrument int, req etudeRequest) (sequence etudeSequence) {
@@ -200,6 +416,11 @@ func generateEqualIntervalSequence(midilo int, midihi int, tempo int, instrument
 	if interval == -1 {
 		panic(fmt.Sprintf("%s is not a supported interval name", req.interval1))
 	}
+	// compound intervals are built from the simple interval an octave smaller
+	simple := interval
+	if interval > 12 {
+		simple = interval - 12
+	}
 
 	// construct the sequence
 	sequence = etudeSequence{
@@ -215,14 +436,30 @@ func generateEqualIntervalSequence(midilo int, midihi int, tempo int, instrument
 		if diff < 0 {
 			diff = -diff
 		}
-		if diff != interval {
+		if diff != simple {
 			continue
 		}
+		if interval > 12 {
+			t = widenPattern(t)
+		}
 		sequence.seq = append(sequence.seq, t)
 	}
 	return
 }
 
+// widenPattern returns a copy of the interval pattern t, e.g. {3, 5, 3},
+// with its middle note moved an octave further from the others, making a
+// simple interval compound, e.g. {3, 17, 3}.
+func widenPattern(t midiPattern) midiPattern {
+	w := midiPattern{t[0], t[1], t[2]}
+	if w[1] < w[0] {
+		w[1] -= 12
+	} else {
+		w[1] += 12
+	}
+	return w
+}
+
 // generateIntervalSequence returns a slice of 12 etudeSequences as described in the usage instructions.
 // Each sequence consists of 12 triples with the middle pitch corresponding to pitchnum.
 func generateIntervalSequence(midilo int, midihi int, tempo int, instrument int, req etudeRequest) (sequence etudeSequence) {
@@ -275,8 +512,7 @@ func generateTwoIntervalSequence(midilo int, midihi int, tempo int, instrument i
 	// Generate all triples
 	patterns := []midiPattern{}
 	for p := range midiChromaticScaleNums {
-		t := tripleFrom2Intervals(p, i1, i2)
-		shufflePatternPitches(&t) // randomize the order
+		t := tripleFrom2Intervals(p, i1, i2) // ascending, reordered below
 		patterns = append(patterns, t)
 	}
 	indices := permute3([]int{0, 1, 2})   // 6 possible note orders
@@ -346,17 +582,36 @@ func generateThreeIntervalSequence(midilo int, midihi int, tempo int, instrument
 // writeMidi file to convert the data to Standard Midi form and write it to
 // disk.
 func mkMidi(sequence *etudeSequence, noTighten bool) {
-	// Shuffle the sequence
-	shufflePatterns(sequence.seq)
+	// Shuffle the sequence unless it follows a chord progression
+	if sequence.chords == nil {
+		shufflePatterns(sequence.seq)
+	}
 
 	// Constrain the sequence assuming random prior pitch within the
-	// instrumen's midi range.
-	prior := rand.Intn(1+sequence.midihi-sequence.midilo) + sequence.midilo
+	// instrumen's midi range. If the request targets registers, move the
+	// prior into each pattern's register first.
+	prior := rng.Intn(1+sequence.midihi-sequence.midilo) + sequence.midilo
 	seqlen := len(sequence.seq)
+	registers := patternRegisters(sequence.req.register, seqlen)
+	fingerings := requestFingerings(&sequence.req)
 	for i := 0; i < seqlen; i++ {
 		t := &(sequence.seq[i])
+		lo, hi := sequence.midilo, sequence.midihi
+		if registers != nil {
+			lo, hi = registerBand(registers[i], sequence.midilo, sequence.midihi)
+			prior = registerPrior(*t, prior, lo, hi)
+		}
 		constrain(t, prior, sequence.midilo, sequence.midihi, noTighten)
-		prior = (*t)[2]
+		if registers != nil {
+			fitRegister(*t, prior, lo, hi)
+		}
+		if fingerings != nil {
+			// validFretting rejects requests whose patterns can't all fit
+			if err := fitFingerings(t, prior, lo, hi, fingerings); err != nil {
+				log.Printf("%s: %v", sequence.req.midiFilename(), err)
+			}
+		}
+		prior = (*t)[len(*t)-1]
 		/*
 			// for the special case of an "allintervals" request swap
 			// the middle pitch (the tonic) with the first and last pitches.
@@ -378,7 +633,7 @@ func shufflePatternPitches(t *midiPattern) {
 	N := len(*t)
 	for i := 0; i < N; i++ {
 		// choose index uniformly in [i, N-1]
-		r := i + rand.Intn(N-i)
+		r := i + rng.Intn(N-i)
 		(*t)[r], (*t)[i] = (*t)[i], (*t)[r]
 	}
 }
@@ -389,7 +644,7 @@ func shufflePatterns(slc []midiPattern) {
 	N := len(slc)
 	for i := 0; i < N; i++ {
 		// choose index uniformly in [i, N-1]
-		r := i + rand.Intn(N-i)
+		r := i + rng.Intn(N-i)
 		slc[r], slc[i] = slc[i], slc[r]
 	}
 }
@@ -403,32 +658,92 @@ func low3(n uint32) (u24 [3]byte) {
 	return u24
 }
 
-// writeMidiFile creates a midi file from an etudeSequence.
-// Each midiTriple in the sequence is placed on beats 1, 2, 3 of
-// a 4/4 measure with rest on beat 4. Each measure is played
-// 4 times accompanied by a metronome track.  The etude begins
-// with a one-bar count-in.
+// ticksPerBeat is the time division given in the midi file header.
+const ticksPerBeat = 960
+
+// ticksPerBar is the length of a 4/4 bar in ticks.
+const ticksPerBar = 4 * ticksPerBeat
+
+// varLen returns n encoded as a midi variable length quantity.
+func varLen(n uint32) []byte {
+	b := []byte{byte(n & 0x7F)}
+	for n >>= 7; n > 0; n >>= 7 {
+		b = append([]byte{byte(n&0x7F) | 0x80}, b...)
+	}
+	return b
+}
+
+// tempoChange is a tempo in beats per minute starting at an absolute time
+// in ticks.
+type tempoChange struct {
+	tick uint32
+	bpm  int
+}
+
+// tempoChanges returns the tempo map for an etude. It holds a single tempo
+// unless the request asks for an accelerando, in which case the tempo steps
+// evenly from the sequence tempo to req.rampTo. Steps fall at the start of
+// every req.rampEvery'th pattern or, if req.rampEvery is 0, on every bar.
+// The count-in is always at the starting tempo.
+func tempoChanges(sequence *etudeSequence) (changes []tempoChange) {
+	changes = []tempoChange{{0, sequence.tempo}}
+	req := &sequence.req
+	if req.rampTo == 0 || req.rampTo == sequence.tempo {
+		return
+	}
+	var steps []uint32        // start tick of each tempo step
+	tick := countInTicks(req) // skip the count-in
+	nbars := 1 + req.repeats
+	for i, t := range sequence.seq {
+		for bar := 0; bar < nbars*cellBars(len(t)); bar++ {
+			if req.rampEvery == 0 || (bar == 0 && i%req.rampEvery == 0) {
+				steps = append(steps, tick)
+			}
+			tick += ticksPerBar
+		}
+	}
+	start, end := sequence.tempo, req.rampTo
+	for k := 1; k < len(steps); k++ {
+		bpm := start + (end-start)*k/(len(steps)-1)
+		changes = append(changes, tempoChange{steps[k], bpm})
+	}
+	return
+}
+
+// writeMidiFile creates a midi file from an etudeSequence and keeps a copy
+// of its content in sequence.midi.
 func writeMidiFile(sequence *etudeSequence) {
 	// update the filename with the rhythm pattern
 	sequence.filename = sequence.req.midiFilename()
-	// open the file
-	fd, err := os.Create(sequence.filename)
+	sequence.midi = midiBytes(sequence)
+	err := ioutil.WriteFile(sequence.filename, sequence.midi, 0644)
 	if err != nil {
-		msg := fmt.Sprintf("Couldn't open output file %s", sequence.filename)
+		msg := fmt.Sprintf("Couldn't write output file %s: %v", sequence.filename, err)
 		panic(msg)
 	}
-	defer fd.Close()
+}
+
+// midiBytes returns the content of a midi file for an etudeSequence.
+// Each midiTriple in the sequence is placed on beats 1, 2, 3 of
+// a 4/4 measure with rest on beat 4. Each measure is played
+// 4 times accompanied by a metronome track.  The etude begins
+// with a count-in, one bar unless the request asks for another.
+func midiBytes(sequence *etudeSequence) []byte {
+	out := new(bytes.Buffer)
 	// write the header "MThd len=6, format=1, tracks=3, ticks=960"
 	header := []byte{0x4d, 0x54, 0x68, 0x64, 0, 0, 0, 6, 0, 1, 0, 3, 3, 192}
-	n, err := fd.Write(header)
-	if err != nil {
-		panic(err)
+	// accompaniment tracks follow the metronome track
+	var accompaniment [][]byte
+	if sequence.req.drone != "" {
+		accompaniment = append(accompaniment, droneTrack(sequence))
 	}
-	if n != len(header) {
-		panic("failed to write header")
+	if sequence.chords != nil {
+		accompaniment = append(accompaniment, backingTrack(sequence))
 	}
+	header[11] += byte(len(accompaniment))
+	out.Write(header)
+	var err error
 	// write the tempo track
-	microseconds := low3(uint32(60000000 / sequence.tempo)) //microseconds per beat
 	var record = []interface{}{
 		// Time signature event
 		byte(0),                // delta time
@@ -437,14 +752,21 @@ func writeMidiFile(sequence *etudeSequence) {
 		byte(2),                // quarter note beat (because 2^2 = 4)
 		byte(24),               // clocks per tick
 		byte(8),                // 32nd's per quarter note
-		// Tempo event
-		byte(0),                // delta time
-		low3(uint32(0xFF5103)), // tempo event
-		microseconds,
-		// EOT event
-		byte(0),                // delta time
-		low3(uint32(0xFF2F00)), // End of track
 	}
+	// Tempo events and cues in time order
+	var events []midiEvent
+	for _, tc := range tempoChanges(sequence) {
+		microseconds := low3(uint32(60000000 / tc.bpm)) //microseconds per beat
+		events = append(events, midiEvent{tc.tick, append([]byte{0xFF, 0x51, 0x03}, microseconds[:]...)})
+	}
+	events = append(events, cueEvents(sequence)...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].tick < events[j].tick })
+	record = append(record,
+		byte(0), // delta time
+		encodeEvents(events, events[len(events)-1].tick),
+		// EOT event (the last delta is already in place)
+		low3(uint32(0xFF2F00)), // End of track
+	)
 	// write the track data to a temporary buffer
 	// so we can compute its length
 	buf := new(bytes.Buffer)
@@ -462,7 +784,7 @@ func writeMidiFile(sequence *etudeSequence) {
 	}
 	// write tempo track to file
 	for _, v := range track {
-		err = binary.Write(fd, binary.BigEndian, v)
+		err = binary.Write(out, binary.BigEndian, v)
 		if err != nil {
 			panic(err)
 		}
@@ -473,8 +795,8 @@ func writeMidiFile(sequence *etudeSequence) {
 	record = []interface{}{
 		keySignature(sequence),
 		trackInstrument(sequence),
-		byte(0x9e), // four beats hi byte
-		byte(0x00), // four beats lo byte
+		callInstrument(sequence),
+		varLen(countInTicks(&sequence.req)), // wait for the count-in
 	}
 	for _, v := range record {
 		err = binary.Write(buf, binary.BigEndian, v)
@@ -503,7 +825,7 @@ func writeMidiFile(sequence *etudeSequence) {
 	}
 	// write instrument track to file
 	for _, v := range track {
-		err = binary.Write(fd, binary.BigEndian, v)
+		err = binary.Write(out, binary.BigEndian, v)
 		if err != nil {
 			panic(err)
 		}
@@ -517,16 +839,32 @@ func writeMidiFile(sequence *etudeSequence) {
 			panic(err)
 		}
 	}
+	bufferMusic(metronomeVolume(&sequence.req))
 	bufferMusic([]byte{0x00})
 
-	// one bar count-in
-	countin := metronomeBars(1, &etudeRequest{metronome: metronomeOn}).Bytes()
-	bufferMusic(countin)
+	bufferMusic(countInMusic(&sequence.req))
 	//
 	nbars := 1 + sequence.req.repeats
-	for i := 0; i < len(sequence.seq); i++ {
-		music := metronomeBars(nbars, &sequence.req).Bytes()
-		bufferMusic(music)
+	switch sequence.req.metronome {
+	case metronomeGaps, metronomeRandomGaps:
+		silent := sequence.req
+		silent.metronome = metronomeOff
+		var total int
+		for _, t := range sequence.seq {
+			total += nbars * cellBars(len(t))
+		}
+		for _, gap := range metronomeGapBars(total, sequence.req.metronome) {
+			if gap {
+				bufferMusic(metronomeBars(1, &silent).Bytes())
+			} else {
+				bufferMusic(metronomeBars(1, &sequence.req).Bytes())
+			}
+		}
+	default:
+		for _, t := range sequence.seq {
+			music := metronomeBars(nbars*cellBars(len(t)), &sequence.req).Bytes()
+			bufferMusic(music)
+		}
 	}
 	// end of track
 	bufferMusic(eot)
@@ -540,105 +878,258 @@ func writeMidiFile(sequence *etudeSequence) {
 	}
 	// write metronome track to file
 	for _, v := range track {
-		err = binary.Write(fd, binary.BigEndian, v)
+		err = binary.Write(out, binary.BigEndian, v)
 		if err != nil {
 			panic(err)
 		}
 	}
 
+	for _, data := range accompaniment {
+		track = []interface{}{
+			[]byte{'M', 'T', 'r', 'k'},
+			uint32(len(data)), // length of track data
+			data,
+		}
+		for _, v := range track {
+			err = binary.Write(out, binary.BigEndian, v)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+	return out.Bytes()
+}
+
+// dronePitch is the lowest pitch used for a drone's root.
+const dronePitch = 48 // C3
+
+// droneTrack returns track data for a drone on the tonal center of sequence,
+// sustained on channel 2 from the end of the count-in to the end of the
+// etude. The drone is the root alone or the root and the fifth above,
+// according to sequence.req.drone.
+func droneTrack(sequence *etudeSequence) []byte {
+	req := &sequence.req
+	sound := gmFileNamePrefixToNum[req.droneSound]
+	root := dronePitch
+	for i, name := range keyNames {
+		if name == req.tonalCenter {
+			root += i
+		}
+	}
+	pitches := []byte{byte(root)}
+	if req.drone == "fifth" {
+		pitches = append(pitches, byte(root+7))
+	}
+	velocity := byte(0x60)
+	var bars uint32
+	for _, t := range sequence.seq {
+		bars += uint32((1 + req.repeats) * cellBars(len(t)))
+	}
+
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0x00, 0xC1, byte(sound)})                 // program change, channel 2
+	buf.Write([]byte{0x00, 0xB1, 0x07, byte(req.droneVolume)}) // channel volume
+	buf.Write(varLen(countInTicks(req)))                       // wait for the count-in
+	for i, p := range pitches {
+		if i > 0 {
+			buf.WriteByte(0x00)
+		}
+		buf.Write([]byte{0x91, p, velocity})
+	}
+	buf.Write(varLen(bars * ticksPerBar))
+	for i, p := range pitches {
+		if i > 0 {
+			buf.WriteByte(0x00)
+		}
+		buf.Write([]byte{0x81, p, velocity})
+	}
+	buf.Write([]byte{0x00, 0xff, 0x2f, 0x00}) // end of track
+	return buf.Bytes()
+}
+
+// mutedBars returns, for each bar of a pattern, whether the bar is muted
+// according to req. The first bar is always played when muting with the
+// silent mask or at random. Random muting chooses anew for each call.
+func mutedBars(req *etudeRequest) (muted []bool) {
+	nbars := 1 + req.repeats
+	muted = make([]bool, nbars)
+	switch req.mute {
+	case "":
+		for i, silent := range iToBools(req.silent, 3) {
+			if i+1 < nbars {
+				muted[i+1] = silent
+			}
+		}
+	case randomMute:
+		for i := 1; i < nbars; i++ {
+			muted[i] = rng.Intn(2) == 0
+		}
+	default:
+		for i := range muted {
+			muted[i] = i < len(req.mute) && req.mute[i] == 'M'
+		}
+	}
+	return
+}
+
+// midiEvent is a channel event at an absolute time in ticks.
+type midiEvent struct {
+	tick uint32
+	data []byte // status and data bytes
+}
+
+// encodeEvents returns track data for events, which must be in time order,
+// lasting length ticks. Each event is followed by the delta time to the next
+// one, or to length after the last event, so the data can be followed
+// directly by another event.
+func encodeEvents(events []midiEvent, length uint32) []byte {
+	buf := new(bytes.Buffer)
+	for i, e := range events {
+		buf.Write(e.data)
+		next := length
+		if i+1 < len(events) {
+			next = events[i+1].tick
+		}
+		buf.Write(varLen(next - e.tick))
+	}
+	return buf.Bytes()
 }
 
-// nBarsMusic returns a byte buffer containing four bars of  one midiPattern
+// Dynamic shapes for etudeRequest.dynamics.
+const (
+	dynamicsCrescendo = "crescendo" // louder with each bar of a pattern
+	dynamicsAccents   = "accents"   // random accented notes
+	dynamicsTerraced  = "terraced"  // a random level for each pattern
+)
+
+// Articulations for etudeRequest.articulation.
+const (
+	articulationStaccato = "staccato" // notes held for half a beat
+	articulationTenuto   = "tenuto"   // notes held for the full beat and slightly stressed
+	articulationLegato   = "legato"   // notes overlap the next note
+)
+
+// terracedLevels are the velocity scale factors for terraced dynamics.
+var terracedLevels = []float64{0.6, 0.9, 1.2}
+
+// legatoOverlap is how long a legato note sounds after the next note starts.
+const legatoOverlap = ticksPerBeat / 16
+
+// dynamicLevels returns a velocity scale factor for each of nbars bars of a
+// pattern according to req.dynamics.
+func dynamicLevels(req *etudeRequest, nbars int) (levels []float64) {
+	level := 1.0
+	if req.dynamics == dynamicsTerraced {
+		level = terracedLevels[rng.Intn(len(terracedLevels))]
+	}
+	for i := 0; i < nbars; i++ {
+		if req.dynamics == dynamicsCrescendo && nbars > 1 {
+			level = 0.6 + 0.6*float64(i)/float64(nbars-1)
+		}
+		levels = append(levels, level)
+	}
+	return
+}
+
+// scaleVelocity returns v scaled by level and clamped to 1-127.
+func scaleVelocity(v byte, level float64) byte {
+	scaled := int(float64(v)*level + 0.5)
+	if scaled < 1 {
+		scaled = 1
+	}
+	if scaled > 127 {
+		scaled = 127
+	}
+	return byte(scaled)
+}
+
+// nBarsMusic returns a byte buffer containing 1 + req.repeats bars of one
+// midiPattern with one note per beat. Patterns of more than 4 notes take
+// cellBars(len(ptn)) bars for each repeat. The buffer ends with the delta
+// time to the end of the last bar.
 func nBarsMusic(ptn midiPattern, req *etudeRequest) *bytes.Buffer {
 	nbars := 1 + req.repeats
-	silent := iToBools(req.silent, 3)
+	muted := mutedBars(req)
 	// There is no valid reason to call this function with nbars < 1, so panic if that happens.
 	if nbars < 1 {
 		panic(fmt.Sprintf("attempted to create etude with %d bars per pattern.", nbars))
 	}
-	// These are the only variable length delta times we need.
-	noBeats := byte(0x00)
-	oneBeatHiByte := byte(0x87)
-	oneBeatLoByte := byte(0x40)
-	// fourBeats := []byte{0x9e, 0x00}
-
 	velocity1 := byte(0x65) // downbeat
 	velocity2 := byte(0x51) // other beats
+	accent := 0x1a          // added to accented notes
 
-	on := byte(0x90)  // Note On, channel 1
-	off := byte(0x80) // Note off, channel 1.
-
-	buf := new(bytes.Buffer)
-	check := func(e error) {
-		if e != nil {
-			panic(e)
-		}
-	}
-	// mkBeat writes MIDI for one beat with note on and off events with
-	// the specified pitch and velocity. If addRest is true, it appends
-	// a second beat of silence.
-	mkBeat := func(buf *bytes.Buffer, pitch byte, velocity byte, addRest bool) {
-		var b []byte
-		switch addRest {
-		case false:
-			b = []byte{on, pitch, velocity, oneBeatHiByte, oneBeatLoByte, off, pitch, velocity, noBeats}
-		case true:
-			b = []byte{on, pitch, velocity, oneBeatHiByte, oneBeatLoByte, off, pitch, velocity, oneBeatHiByte, oneBeatLoByte}
+	levels := dynamicLevels(req, nbars)
+	passTicks := cellBars(len(ptn)) * ticksPerBar // length of each repeat
+	var events []midiEvent
+	var cents []float64 // tuning of each note in ptn
+	if req.tuning != "" {
+		for _, p := range ptn {
+			cents = append(cents, noteCents(p, ptn, req))
 		}
-		check(binary.Write(buf, binary.BigEndian, b))
-	}
-	silence := func(barnum int, velocity byte) (adjustedVelocity byte) {
-		switch barnum {
-		case 0:
-			adjustedVelocity = velocity
-		default:
-			if silent[barnum-1] {
-				adjustedVelocity = 0
-			} else {
-				adjustedVelocity = velocity
-			}
+		if req.tuningMode == tuningModeMTS {
+			// the etude's channel and the call's
+			events = append(events, midiEvent{0, mtsScaleTuning(tuningReference(ptn, req), tuningCents[req.tuning], 0, 3)})
 		}
-		return
 	}
 	// write all n bars for this pattern
 	for i := 0; i < nbars; i++ {
-		v1 := silence(i, velocity1)
-		v2 := silence(i, velocity2)
-		var pitch byte
-		// first beat
-		pitch = byte(ptn[0])
-		mkBeat(buf, pitch, v1, false)
-		// 2nd beat
-		pitch = byte(ptn[1])
-		mkBeat(buf, pitch, v2, false)
-		switch len(ptn) {
-		case 3: // triple pattern
-			// 3rd beat (4th beat is a rest, so we append a one beat of silence.
-			pitch = byte(ptn[2])
-			mkBeat(buf, pitch, v2, true)
-		case 4: // quad pattern
-			// 3rd and 4th beats
-			pitch = byte(ptn[2])
-			mkBeat(buf, pitch, v2, false)
-			pitch = byte(ptn[3])
-			mkBeat(buf, pitch, v2, false)
-
+		on, off := byte(0x90), byte(0x80) // Note On and Off, channel 1
+		if i == 0 && req.callSound != "" {
+			on, off = 0x93, 0x83 // the call is on channel 4
+		}
+		for j, p := range ptn {
+			velocity := velocity2
+			if j == 0 {
+				velocity = velocity1
+			}
+			velocity = scaleVelocity(velocity, levels[i])
+			if req.dynamics == dynamicsAccents && rng.Intn(4) == 0 {
+				velocity = scaleVelocity(velocity+byte(accent), 1)
+			}
+			if req.articulation == articulationTenuto {
+				velocity = scaleVelocity(velocity+8, 1)
+			}
+			if muted[i] {
+				velocity = 0
+			}
+			start := uint32(i*passTicks + j*ticksPerBeat)
+			length := uint32(ticksPerBeat)
+			switch req.articulation {
+			case articulationStaccato:
+				length = ticksPerBeat / 2
+			case articulationLegato:
+				// overlap the next note in the pattern unless it's the same pitch
+				if j+1 < len(ptn) && ptn[j+1] != p {
+					length += legatoOverlap
+				}
+			}
+			if req.syllables != "" && !muted[i] {
+				events = append(events, midiEvent{start, metaEvent(metaLyric, noteSyllable(p, ptn, req, req.syllables))})
+			}
+			if cents != nil && req.tuningMode == tuningModeBend {
+				events = append(events, midiEvent{start, pitchBend(on&0x0F, cents[j])})
+			}
+			events = append(events,
+				midiEvent{start, []byte{on, byte(p), velocity}},
+				midiEvent{start + length, []byte{off, byte(p), velocity}})
 		}
 	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].tick < events[j].tick })
+	buf := new(bytes.Buffer)
+	buf.Write(encodeEvents(events, uint32(nbars*passTicks)))
 	return buf
 }
 
 // metronomeBars returns a byte buffer containing n bars of metronome click.
-// Downbeats use a High Wood Block sound. Other beats use a Low Wood Block,
+// Downbeats use the accent key of the requested sound, a High Wood Block by
+// default. Other beats and subdivisions use the other key, a Low Wood Block
+// by default.
 func metronomeBars(n int, req *etudeRequest) *bytes.Buffer {
-	// These are the only variable length delta times we need.
-	noBeats := byte(0x00)
-	oneBeatHi := byte(0x87)
-	oneBeatLo := byte(0x40)
 	// adjust velocities according to request
-	var velocity1, velocity2 byte
+	var velocity1, velocity2, velocitySub byte
+	subdivisions := 1 // clicks per beat
 	switch req.metronome {
-	case metronomeOn:
+	case metronomeOn, metronomeBackbeat, metronomeGaps, metronomeRandomGaps:
 		velocity1 = byte(0x30) // downbeat
 		velocity2 = byte(0x10) // other beats
 		// no adjusment
@@ -647,42 +1138,116 @@ func metronomeBars(n int, req *etudeRequest) *bytes.Buffer {
 		velocity2 = byte(0x00) // other beats
 	case metronomeOff:
 		velocity1, velocity2 = 0, 0
+	case metronomeEighths:
+		velocity1, velocity2, velocitySub = 0x30, 0x10, 0x08
+		subdivisions = 2
+	case metronomeTriplets:
+		velocity1, velocity2, velocitySub = 0x30, 0x10, 0x08
+		subdivisions = 3
 	default:
-		panic("programming error: %d is not a supported value for etudeRequest.metronome.")
+		panic(fmt.Sprintf("programming error: %d is not a supported value for etudeRequest.metronome.", req.metronome))
+	}
+	sound, ok := metronomeSoundByName(req.metroSound)
+	if !ok {
+		panic(fmt.Sprintf("programming error: %s is not a supported metronome sound.", req.metroSound))
+	}
+	pitches := [4]byte{sound.accent, sound.other, sound.other, sound.other}
+	velocities := [4]byte{velocity1, velocity2, velocity2, velocity2}
+	if req.metronome == metronomeBackbeat {
+		pitches = [4]byte{sound.other, sound.accent, sound.other, sound.accent}
+		velocities = [4]byte{0, velocity1, 0, velocity1}
 	}
 
 	on := byte(0x99)  // Note On, channel 10
 	off := byte(0x89) // Note off, channel 10
 
-	wbh := byte(0x4c) // wood block hi for downbeats
-	wbl := byte(0x4d) // wood block lo for other beats
-
 	buf := new(bytes.Buffer)
 	check := func(e error) {
 		if e != nil {
 			panic(e)
 		}
 	}
-	// mkBeat writes MIDI for one beat with note on and off events.
-	mkBeat := func(buf *bytes.Buffer, pitch byte, velocity byte) {
-		b := []byte{on, pitch, velocity, oneBeatHi, oneBeatLo, off, pitch, velocity, noBeats}
+	// mkClick writes MIDI for one click lasting ticks with note on and off
+	// events.
+	mkClick := func(buf *bytes.Buffer, pitch byte, velocity byte, ticks uint32) {
+		b := []byte{on, pitch, velocity}
+		b = append(b, varLen(ticks)...)
+		b = append(b, off, pitch, velocity, 0x00)
 		check(binary.Write(buf, binary.BigEndian, b))
 	}
+	// mkBeat writes MIDI for one beat divided into subdivisions clicks.
+	mkBeat := func(buf *bytes.Buffer, pitch byte, velocity byte) {
+		sub := uint32(ticksPerBeat / subdivisions)
+		mkClick(buf, pitch, velocity, sub)
+		for i := 1; i < subdivisions; i++ {
+			mkClick(buf, sound.other, velocitySub, sub)
+		}
+	}
 
 	// write as many bars as requested
 	for i := 0; i < n; i++ {
-		// first beat
-		mkBeat(buf, wbh, velocity1)
-		// 2nd beat
-		mkBeat(buf, wbl, velocity2)
-		// 3rd beat
-		mkBeat(buf, wbl, velocity2)
-		// 4th beat
-		mkBeat(buf, wbl, velocity2)
+		for beat := 0; beat < 4; beat++ {
+			mkBeat(buf, pitches[beat], velocities[beat])
+		}
 	}
 	return buf
 }
 
+// The gap click modes keep the click for gapClickBars bars between gaps of
+// at most maxGapBars silent bars.
+const (
+	gapClickBars = 2
+	maxGapBars   = 4
+)
+
+// metronomeGapBars returns, for each of n bars following the count-in,
+// whether the metronome drops out for that bar. With metronomeGaps the
+// gaps grow by a bar each time up to maxGapBars. With metronomeRandomGaps
+// each bar after the first gapClickBars is dropped with even odds, but
+// never more than maxGapBars in a row.
+func metronomeGapBars(n int, mode int) (gaps []bool) {
+	switch mode {
+	case metronomeGaps:
+		gap := 1
+		for len(gaps) < n {
+			for i := 0; i < gapClickBars; i++ {
+				gaps = append(gaps, false)
+			}
+			for i := 0; i < gap; i++ {
+				gaps = append(gaps, true)
+			}
+			if gap < maxGapBars {
+				gap++
+			}
+		}
+		gaps = gaps[:n]
+	case metronomeRandomGaps:
+		run := 0 // silent bars in a row
+		for i := 0; i < n; i++ {
+			gap := i >= gapClickBars && run < maxGapBars && rng.Intn(2) == 0
+			if gap {
+				run++
+			} else {
+				run = 0
+			}
+			gaps = append(gaps, gap)
+		}
+	default:
+		gaps = make([]bool, n)
+	}
+	return
+}
+
+// metronomeVolume returns a Control Change event setting the channel 10
+// volume for req, preceded by zero delta time, or nil to leave the
+// player's default volume.
+func metronomeVolume(req *etudeRequest) []byte {
+	if req.metroVolume == 0 {
+		return nil
+	}
+	return []byte{0x00, 0xB9, 0x07, byte(req.metroVolume)}
+}
+
 // keySignature returns a MIDI KeySignature event preceeded by zero delta time.
 func keySignature(s *etudeSequence) []byte {
 	sharps := keySharps[s.keyname]
@@ -697,6 +1262,15 @@ func trackInstrument(s *etudeSequence) []byte {
 	return []byte{0x00, 0xC0, byte(s.instrument)}
 }
 
+// callInstrument returns a Program Change event for the call sound on
+// channel 4 preceded by 0 delta time, or nil if s has no call sound.
+func callInstrument(s *etudeSequence) []byte {
+	if s.req.callSound == "" {
+		return nil
+	}
+	return []byte{0x00, 0xC3, byte(gmFileNamePrefixToNum[s.req.callSound])}
+}
+
 // adjustSuccessor returns a pitch adjusted to be within
 // 6 semitones of its predecessor.
 func adjustSuccessor(p0 int, p1 int) (adjustedP1 int) {
@@ -739,37 +1313,33 @@ func constrain(t *midiPattern, prior int, midilo int, midihi int, noTighten bool
 	for i := 0; i < len(*t); i++ {
 		(*t)[i] += offset
 	}
-	// If needed, shift pitches by octaves until all are between midilo and midihi inclusive.
-	lo := int(midilo)
-	// anylow tests if any pitches are too low
-	anylow := func() bool {
-		for _, p := range *t {
-			if p < lo {
-				return true
-			}
+	// If needed, shift pitches by octaves until all are between midilo and
+	// midihi inclusive. Shifting by octaves keeps wide (e.g. compound)
+	// intervals intact.
+	lo, hi := (*t)[0], (*t)[0]
+	for _, p := range *t {
+		if p < lo {
+			lo = p
 		}
-		return false
-	}
-	// adjust until none are too low
-	for anylow() {
-		for i := range *t {
-			(*t)[i] += 12
+		if p > hi {
+			hi = p
 		}
 	}
-	hi := int(midihi)
-	// anyhigh tests if any pitches are too high
-	anyhigh := func() bool {
-		for _, p := range *t {
-			if p > hi {
-				return true
-			}
-		}
-		return false
+	shift := 0
+	// adjust until none are too low
+	for lo+shift < midilo {
+		shift += 12
 	}
 	// adjust until none are too high
-	for anyhigh() {
-		for i := range *t {
-			(*t)[i] -= 12
-		}
+	for hi+shift > midihi && lo+shift-12 >= midilo {
+		shift -= 12
+	}
+	// If no octave fits the whole pattern, let whichever end sticks out less
+	// go past the limit.
+	if over := hi + shift - midihi; over > 0 && over > midilo-(lo+shift-12) {
+		shift -= 12
+	}
+	for i := range *t {
+		(*t)[i] += shift
 	}
 }
@@ -3,11 +3,12 @@ package main
 import "fmt"
 
 type instrumentInfo struct {
-	displayName string // what we show in the UI
-	gmnumber    int    // General Midi Sound number (1-indexed)
-	name        string // used in file names
-	midilo      int    // lowest midi pitch to be used
-	midihi      int    // highest midi pitch to be used
+	displayName string     // what we show in the UI
+	gmnumber    int        // General Midi Sound number (1-indexed)
+	name        string     // used in file names
+	midilo      int        // lowest midi pitch to be used
+	midihi      int        // highest midi pitch to be used
+	fretboard   *fretboard // strings and frets of guitars and basses, nil for others
 }
 
 // getSupportedInstrumentByName returns the instrumentInfo
@@ -33,6 +34,7 @@ var supportedInstruments = []instrumentInfo{
 		name:        "acoustic_bass",
 		midilo:      28,
 		midihi:      55,
+		fretboard:   bass12,
 	},
 	{
 		displayName: "Bass, Electric",
@@ -40,6 +42,7 @@ var supportedInstruments = []instrumentInfo{
 		name:        "electric_bass_finger",
 		midilo:      28,
 		midihi:      67,
+		fretboard:   bass24,
 	},
 	{
 		displayName: "Bassoon",
@@ -75,6 +78,7 @@ var supportedInstruments = []instrumentInfo{
 		name:        "acoustic_guitar_steel",
 		midilo:      40,
 		midihi:      76,
+		fretboard:   guitar12,
 	},
 	{
 		displayName: "Guitar, Electric",
@@ -82,6 +86,7 @@ var supportedInstruments = []instrumentInfo{
 		name:        "electric_guitar_jazz",
 		midilo:      40,
 		midihi:      88,
+		fretboard:   guitar24,
 	},
 	{
 		displayName: "Oboe",
@@ -0,0 +1,160 @@
+package miditempo
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Excerpt returns a copy of the midi file content, data, that keeps the
+// events of each track before tick lead, e.g. a count-in, and follows them
+// with the events from tick from up to tick to. Notes sounding at lead or
+// at to are ended there, and notes sounding at from are struck again where
+// the excerpt joins the lead. The tempo, program changes, controllers,
+// pitch bends and SysEx events in effect at from take effect at the join.
+func Excerpt(data []byte, lead, from, to uint32) (out []byte, err error) {
+	if from < lead || to < from {
+		err = fmt.Errorf("bad excerpt: lead %d, from %d, to %d", lead, from, to)
+		return
+	}
+	chunks, err := splitChunks(data)
+	if err != nil {
+		return
+	}
+	out = append(out, data[:chunks[0].end]...)
+	for _, c := range chunks[1:] {
+		if c.id != "MTrk" {
+			out = append(out, data[c.start-8:c.end]...)
+			continue
+		}
+		events, e := parseTrack(data[c.start:c.end])
+		if e != nil {
+			err = e
+			return
+		}
+		trk := encodeTrack(excerptEvents(events, lead, from, to))
+		out = append(out, 'M', 'T', 'r', 'k', byte(len(trk)>>24), byte(len(trk)>>16), byte(len(trk)>>8), byte(len(trk)))
+		out = append(out, trk...)
+	}
+	return
+}
+
+// excerptEvents returns the events of a track for Excerpt, ending with an
+// end of track event.
+func excerptEvents(events []trackEvent, lead, from, to uint32) (kept []trackEvent) {
+	sounding := map[uint16][]byte{} // note on events by channel<<8 | key
+	var state []trackEvent          // events in effect, by stateKey
+	// the lead
+	for _, e := range events {
+		if e.tick > lead {
+			break
+		}
+		if e.tick == lead && !e.isNoteOff() || e.isEndOfTrack() {
+			continue
+		}
+		e.track(sounding)
+		kept = append(kept, e)
+	}
+	kept = append(kept, noteOffs(sounding, lead)...)
+	// what is in effect at from
+	for _, e := range events {
+		if e.tick > from {
+			break
+		}
+		if e.tick == from && !e.isNoteOff() {
+			continue
+		}
+		e.track(sounding)
+		if e.tick < lead {
+			continue // already in the lead
+		}
+		if key := e.stateKey(); key != "" {
+			replaced := false
+			for i := range state {
+				if state[i].stateKey() == key {
+					state[i], replaced = e, true
+				}
+			}
+			if !replaced {
+				state = append(state, e)
+			}
+		}
+	}
+	for _, e := range state {
+		kept = append(kept, trackEvent{tick: lead, raw: e.raw})
+	}
+	for _, key := range soundingKeys(sounding) {
+		kept = append(kept, trackEvent{tick: lead, raw: sounding[key]})
+	}
+	// the excerpt
+	end := lead + to - from
+	for _, e := range events {
+		if e.tick < from || e.tick == from && e.isNoteOff() || e.isEndOfTrack() {
+			continue
+		}
+		if e.tick > to {
+			break
+		}
+		if e.tick == to && !e.isNoteOff() {
+			continue
+		}
+		e.track(sounding)
+		kept = append(kept, trackEvent{tick: e.tick - from + lead, raw: e.raw})
+	}
+	kept = append(kept, noteOffs(sounding, end)...)
+	return append(kept, trackEvent{tick: end, raw: []byte{0xFF, 0x2F, 0x00}})
+}
+
+// isNoteOff returns true for a note off event, including a note on with
+// zero velocity.
+func (e trackEvent) isNoteOff() bool {
+	return len(e.raw) == 3 && (e.raw[0]&0xF0 == 0x80 || e.raw[0]&0xF0 == 0x90 && e.raw[2] == 0)
+}
+
+// track records in sounding the note that e starts or ends, if any.
+func (e trackEvent) track(sounding map[uint16][]byte) {
+	if len(e.raw) != 3 || e.raw[0]&0xE0 != 0x80 {
+		return
+	}
+	key := uint16(e.raw[0]&0x0F)<<8 | uint16(e.raw[1])
+	if e.isNoteOff() {
+		delete(sounding, key)
+	} else {
+		sounding[key] = e.raw
+	}
+}
+
+// stateKey returns a key shared by the events that replace e's effect, or
+// "" if e has no lasting effect.
+func (e trackEvent) stateKey() string {
+	switch {
+	case e.isTempo():
+		return "tempo"
+	case e.raw[0] == 0xF0:
+		return "sysex"
+	case e.raw[0]&0xF0 == 0xB0:
+		return fmt.Sprintf("%02X%02X", e.raw[0], e.raw[1])
+	case e.raw[0]&0xF0 == 0xC0 || e.raw[0]&0xF0 == 0xE0:
+		return fmt.Sprintf("%02X", e.raw[0])
+	}
+	return ""
+}
+
+// noteOffs returns note off events at tick for the notes in sounding and
+// empties it.
+func noteOffs(sounding map[uint16][]byte, tick uint32) (offs []trackEvent) {
+	for _, key := range soundingKeys(sounding) {
+		on := sounding[key]
+		offs = append(offs, trackEvent{tick: tick, raw: []byte{0x80 | on[0]&0x0F, on[1], 0x40}})
+		delete(sounding, key)
+	}
+	return
+}
+
+// soundingKeys returns the keys of sounding in order.
+func soundingKeys(sounding map[uint16][]byte) (keys []uint16) {
+	for key := range sounding {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return
+}
@@ -35,6 +35,13 @@ func getFileTempo(filepath string) (addr int, tempoMs uint, err error) {
 		err = fmt.Errorf("%v", err)
 		return
 	}
+	addr, tempoMs, err = GetTempoBytes(bytes)
+	return
+}
+
+// GetTempoBytes finds and returns address and value of the first midi
+// microseconds per beat event in the midi file content, bytes.
+func GetTempoBytes(bytes []byte) (addr int, tempoMs uint, err error) {
 	// tempo events start with 0xFF5103 followed by 3 bytes whose
 	// value is the tempo in µsec.
 	var state int // will be 5 when we have the entire sequence
@@ -87,25 +94,34 @@ func low3(n uint) (u24 [3]byte) {
 // SetTempo returns a new copy of the file's content with the tempo
 // event altered so that its value is the requested number of microseconds
 func SetTempo(filepath string, µs uint) (bytes []byte, err error) {
+	bytes, err = getFileBytes(filepath)
+	if err != nil {
+		return
+	}
+	bytes, err = SetTempoBytes(bytes, µs)
+	return
+}
+
+// SetTempoBytes returns a new copy of the midi file content, data, with the
+// first tempo event altered so that its value is the requested number of
+// microseconds.
+func SetTempoBytes(data []byte, µs uint) (bytes []byte, err error) {
 	if µs == 0 {
 		err = fmt.Errorf("%d is too small for a midi SetTempo event value", µs)
 		return
 	}
-	if µs > 0xFFFFFFF {
+	if µs > 0xFFFFFF {
 		err = fmt.Errorf("%d is too large for a midi SetTempo event value", µs)
 		return
 	}
-	bytes, err = getFileBytes(filepath)
-	if err != nil {
-		return
-	}
-	addr, _, err := getFileTempo(filepath)
+	addr, _, err := GetTempoBytes(data)
 	if err != nil {
 		return
 	}
+	bytes = make([]byte, len(data))
+	copy(bytes, data)
 	for i, b := range low3(µs) {
-		bytes[i + addr] = b
+		bytes[i+addr] = b
 	}
-
-    return
+	return
 }
@@ -4,10 +4,56 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
-var fName = "c_pentatonic_acoustic_bass_steady.mid"
+var fName string
+
+// testMidi returns a small format 1 midi file with a tempo track setting 120
+// bpm and one instrument track with running status note events.
+func testMidi() []byte {
+	header := []byte{'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 1, 0, 2, 3, 192}
+	tempoTrack := []byte{
+		0x00, 0xFF, 0x58, 0x04, 4, 2, 24, 8, // time signature
+		0x00, 0xFF, 0x51, 0x03, 0x07, 0xA1, 0x20, // 500000 µs per beat
+		0x00, 0xFF, 0x2F, 0x00,
+	}
+	noteTrack := []byte{
+		0x00, 0xC0, 0x00, // program change
+		0x00, 0x90, 0x3C, 0x65,
+		0x87, 0x40, 0x3C, 0x00, // running status note off via velocity 0
+		0x00, 0x40, 0x51,
+		0x87, 0x40, 0x80, 0x40, 0x51,
+		0x00, 0xFF, 0x2F, 0x00,
+	}
+	var data []byte
+	data = append(data, header...)
+	for _, trk := range [][]byte{tempoTrack, noteTrack} {
+		n := len(trk)
+		data = append(data, 'M', 'T', 'r', 'k', byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		data = append(data, trk...)
+	}
+	return data
+}
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "miditempo")
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(-1)
+	}
+	fName = filepath.Join(dir, "test120.mid")
+	err = ioutil.WriteFile(fName, testMidi(), 0644)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(-1)
+	}
+	exitcode := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(exitcode)
+}
 
 func TestGetTempo(t *testing.T) {
 	addr, µs, err := GetTempo(fName)
@@ -26,7 +72,7 @@ func TestSetTempo(t *testing.T) {
 	if err != nil {
 		t.Errorf("%v", err)
 	}
-	outfile := "/tmp/test.mid"
+	outfile := filepath.Join(filepath.Dir(fName), "test.mid")
 	err = ioutil.WriteFile(outfile, bytes, 0644)
 	if err != nil {
 		t.Errorf("%v", err)
@@ -42,3 +88,140 @@ func TestSetTempo(t *testing.T) {
 		t.Errorf("exp %d, got %d", µs, gotµs)
 	}
 }
+
+func TestTempoMap(t *testing.T) {
+	data := testMidi()
+	got, err := GetTempoMap(data)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	exp := []TempoEvent{{0, 500000}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("exp %v, got %v", exp, got)
+	}
+	exp = []TempoEvent{{0, 600000}, {1920, 500000}, {3840, 400000}}
+	out, err := SetTempoMap(data, exp)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got, err = GetTempoMap(out)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("exp %v, got %v", exp, got)
+	}
+	// the instrument track must be untouched
+	n := len(data) - len(testMidi()[:14+8+19])
+	if !reflect.DeepEqual(out[len(out)-n:], data[len(data)-n:]) {
+		t.Errorf("instrument track changed")
+	}
+	// end of track must remain the last event of the tempo track
+	chunks, _ := splitChunks(out)
+	events, err := parseTrack(out[chunks[1].start:chunks[1].end])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	last := events[len(events)-1]
+	if !last.isEndOfTrack() || last.tick != 3840 {
+		t.Errorf("expected end of track at 3840, got %v", last)
+	}
+	if _, err = SetTempoMap(data, []TempoEvent{{0, 0}}); err == nil {
+		t.Errorf("expected an error for a zero tempo")
+	}
+}
+
+func TestGetEvents(t *testing.T) {
+	got, err := GetEvents(testMidi())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	exp := []Event{
+		{0, []byte{0xC0, 0x00}},
+		{0, []byte{0x90, 0x3C, 0x65}},
+		{960, []byte{0x90, 0x3C, 0x00}},
+		{960, []byte{0x90, 0x40, 0x51}},
+		{1920, []byte{0x80, 0x40, 0x51}},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("exp %v, got %v", exp, got)
+	}
+	if _, err = GetEvents([]byte("MThd")); err == nil {
+		t.Errorf("expected an error for a truncated file")
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	for _, test := range []struct {
+		lead, from, to uint32
+		exp            []Event
+	}{
+		// the first note is cut short by the lead, the second follows it
+		{480, 960, 1920, []Event{
+			{0, []byte{0xC0, 0x00}},
+			{0, []byte{0x90, 0x3C, 0x65}},
+			{480, []byte{0x80, 0x3C, 0x40}},
+			{480, []byte{0x90, 0x40, 0x51}},
+			{1440, []byte{0x80, 0x40, 0x51}},
+		}},
+		// the first note is struck again at the join and ended at the end
+		{0, 480, 720, []Event{
+			{0, []byte{0xC0, 0x00}},
+			{0, []byte{0x90, 0x3C, 0x65}},
+			{240, []byte{0x80, 0x3C, 0x40}},
+		}},
+	} {
+		out, err := Excerpt(testMidi(), test.lead, test.from, test.to)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		got, err := GetEvents(out)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("%d, %d, %d: exp %v, got %v", test.lead, test.from, test.to, test.exp, got)
+		}
+		tempos, err := GetTempoMap(out)
+		if err != nil || len(tempos) != 1 || tempos[0].MicrosPerBeat != 500000 {
+			t.Errorf("expected the tempo kept, got %v, %v", tempos, err)
+		}
+	}
+	if _, err := Excerpt(testMidi(), 960, 480, 1920); err == nil {
+		t.Errorf("expected an error for an excerpt before the lead")
+	}
+}
+
+func TestVarLen(t *testing.T) {
+	for _, v := range []uint32{0, 0x40, 0x7F, 0x80, 960, 3840, 0x1FFFFF, 0x0FFFFFFF} {
+		got, n, err := readVarLen(varLen(v))
+		if err != nil || got != v || n != len(varLen(v)) {
+			t.Errorf("%d: got %d, %d, %v", v, got, n, err)
+		}
+	}
+	if b := varLen(960); !reflect.DeepEqual(b, []byte{0x87, 0x40}) {
+		t.Errorf("expected 0x87 0x40 for one beat, got % x", b)
+	}
+}
+
+func TestSetTempoBytes(t *testing.T) {
+	data := testMidi()
+	out, err := SetTempoBytes(data, 400000)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, µs, err := GetTempoBytes(out)
+	if err != nil || µs != 400000 {
+		t.Errorf("exp %d, got %d, %v", 400000, µs, err)
+	}
+	// the original content must be untouched
+	if _, µs, _ = GetTempoBytes(data); µs != 500000 {
+		t.Errorf("input was modified, got %d", µs)
+	}
+	if _, err = SetTempoBytes(data, 0x1000000); err == nil {
+		t.Errorf("expected an error for a tempo too large")
+	}
+	if _, err = SetTempoBytes([]byte{0, 1, 2}, 400000); err == nil {
+		t.Errorf("expected an error for missing tempo event")
+	}
+}
@@ -0,0 +1,301 @@
+package miditempo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// TempoEvent is a Set Tempo meta event at an absolute time in ticks.
+type TempoEvent struct {
+	Tick          uint32 // ticks from the start of the track
+	MicrosPerBeat uint   // microseconds per quarter note
+}
+
+// trackEvent is one event of a track chunk. Raw holds the complete event
+// (status byte included) without its delta time.
+type trackEvent struct {
+	tick uint32
+	raw  []byte
+}
+
+func (e trackEvent) isTempo() bool {
+	return len(e.raw) == 6 && e.raw[0] == 0xFF && e.raw[1] == 0x51 && e.raw[2] == 0x03
+}
+
+func (e trackEvent) isEndOfTrack() bool {
+	return len(e.raw) == 3 && e.raw[0] == 0xFF && e.raw[1] == 0x2F
+}
+
+// readVarLen decodes the variable length quantity at the start of b and
+// returns its value and length in bytes.
+func readVarLen(b []byte) (v uint32, n int, err error) {
+	for n < len(b) && n < 4 {
+		c := b[n]
+		n++
+		v = v<<7 | uint32(c&0x7F)
+		if c&0x80 == 0 {
+			return
+		}
+	}
+	err = fmt.Errorf("bad variable length quantity")
+	return
+}
+
+// varLen encodes v as a variable length quantity.
+func varLen(v uint32) []byte {
+	b := []byte{byte(v & 0x7F)}
+	for v >>= 7; v > 0; v >>= 7 {
+		b = append([]byte{byte(v&0x7F) | 0x80}, b...)
+	}
+	return b
+}
+
+// chunk is a span of a midi file holding one chunk's data.
+type chunk struct {
+	id         string
+	start, end int // offsets of the chunk data within the file
+}
+
+// splitChunks returns the chunks of a Standard Midi File.
+func splitChunks(data []byte) (chunks []chunk, err error) {
+	for i := 0; i < len(data); {
+		if i+8 > len(data) {
+			err = fmt.Errorf("truncated chunk header at offset %d", i)
+			return
+		}
+		length := int(binary.BigEndian.Uint32(data[i+4 : i+8]))
+		c := chunk{id: string(data[i : i+4]), start: i + 8, end: i + 8 + length}
+		if c.end > len(data) {
+			err = fmt.Errorf("truncated %s chunk at offset %d", c.id, i)
+			return
+		}
+		chunks = append(chunks, c)
+		i = c.end
+	}
+	if len(chunks) == 0 || chunks[0].id != "MThd" {
+		err = fmt.Errorf("not a midi file")
+	}
+	return
+}
+
+// parseTrack decodes the events of a track chunk's data.
+func parseTrack(data []byte) (events []trackEvent, err error) {
+	var tick uint32
+	var running byte
+	for i := 0; i < len(data); {
+		delta, n, e := readVarLen(data[i:])
+		if e != nil {
+			err = fmt.Errorf("offset %d: %v", i, e)
+			return
+		}
+		i += n
+		tick += delta
+		if i >= len(data) {
+			err = fmt.Errorf("missing event at offset %d", i)
+			return
+		}
+		start := i
+		status := data[i]
+		switch {
+		case status == 0xFF: // meta event
+			if i+2 > len(data) {
+				err = fmt.Errorf("truncated meta event at offset %d", i)
+				return
+			}
+			length, n, e := readVarLen(data[i+2:])
+			if e != nil {
+				err = fmt.Errorf("offset %d: %v", i, e)
+				return
+			}
+			i += 2 + n + int(length)
+		case status == 0xF0 || status == 0xF7: // sysex
+			length, n, e := readVarLen(data[i+1:])
+			if e != nil {
+				err = fmt.Errorf("offset %d: %v", i, e)
+				return
+			}
+			i += 1 + n + int(length)
+		default: // channel message
+			var raw []byte
+			if status&0x80 != 0 {
+				running = status
+				i++
+			} else if running == 0 {
+				err = fmt.Errorf("data byte without status at offset %d", i)
+				return
+			}
+			ndata := 2
+			if running&0xF0 == 0xC0 || running&0xF0 == 0xD0 {
+				ndata = 1
+			}
+			if i+ndata > len(data) {
+				err = fmt.Errorf("truncated channel message at offset %d", start)
+				return
+			}
+			raw = append([]byte{running}, data[i:i+ndata]...)
+			i += ndata
+			events = append(events, trackEvent{tick: tick, raw: raw})
+			continue
+		}
+		if i > len(data) {
+			err = fmt.Errorf("truncated event at offset %d", start)
+			return
+		}
+		events = append(events, trackEvent{tick: tick, raw: data[start:i]})
+	}
+	return
+}
+
+// encodeTrack returns track chunk data for events, which must be in time
+// order.
+func encodeTrack(events []trackEvent) []byte {
+	buf := new(bytes.Buffer)
+	var tick uint32
+	for _, e := range events {
+		buf.Write(varLen(e.tick - tick))
+		buf.Write(e.raw)
+		tick = e.tick
+	}
+	return buf.Bytes()
+}
+
+// GetTempoMap returns all the Set Tempo events in the midi file content,
+// data, in time order.
+func GetTempoMap(data []byte) (tempos []TempoEvent, err error) {
+	chunks, err := splitChunks(data)
+	if err != nil {
+		return
+	}
+	for _, c := range chunks {
+		if c.id != "MTrk" {
+			continue
+		}
+		events, e := parseTrack(data[c.start:c.end])
+		if e != nil {
+			err = e
+			return
+		}
+		for _, e := range events {
+			if e.isTempo() {
+				µs := uint(e.raw[3])<<16 | uint(e.raw[4])<<8 | uint(e.raw[5])
+				tempos = append(tempos, TempoEvent{Tick: e.tick, MicrosPerBeat: µs})
+			}
+		}
+	}
+	if len(tempos) == 0 {
+		err = fmt.Errorf("tempo event not found")
+		return
+	}
+	sort.SliceStable(tempos, func(i, j int) bool { return tempos[i].Tick < tempos[j].Tick })
+	return
+}
+
+// Event is a channel message or SysEx event at an absolute time in ticks.
+// Data holds the complete event, status byte included, even where the file
+// uses running status.
+type Event struct {
+	Tick uint32 // ticks from the start of the track
+	Data []byte
+}
+
+// GetEvents returns the channel messages and SysEx events of all tracks in
+// the midi file content, data, in time order. Events at the same tick keep
+// the order of their tracks.
+func GetEvents(data []byte) (events []Event, err error) {
+	chunks, err := splitChunks(data)
+	if err != nil {
+		return
+	}
+	for _, c := range chunks {
+		if c.id != "MTrk" {
+			continue
+		}
+		trk, e := parseTrack(data[c.start:c.end])
+		if e != nil {
+			err = e
+			return
+		}
+		for _, e := range trk {
+			if e.raw[0] != 0xFF {
+				events = append(events, Event{Tick: e.tick, Data: e.raw})
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Tick < events[j].Tick })
+	return
+}
+
+// SetTempoMap returns a copy of the midi file content, data, with the Set
+// Tempo events in its first track replaced by tempos.
+func SetTempoMap(data []byte, tempos []TempoEvent) (out []byte, err error) {
+	if len(tempos) == 0 {
+		err = fmt.Errorf("empty tempo map")
+		return
+	}
+	for _, t := range tempos {
+		if t.MicrosPerBeat == 0 || t.MicrosPerBeat > 0xFFFFFF {
+			err = fmt.Errorf("%d is out of range for a midi SetTempo event value", t.MicrosPerBeat)
+			return
+		}
+	}
+	chunks, err := splitChunks(data)
+	if err != nil {
+		return
+	}
+	track := -1
+	for i, c := range chunks {
+		if c.id == "MTrk" {
+			track = i
+			break
+		}
+	}
+	if track == -1 {
+		err = fmt.Errorf("no track chunk found")
+		return
+	}
+	c := chunks[track]
+	events, err := parseTrack(data[c.start:c.end])
+	if err != nil {
+		return
+	}
+	var kept []trackEvent
+	for _, e := range events {
+		if !e.isTempo() {
+			kept = append(kept, e)
+		}
+	}
+	for _, t := range tempos {
+		u24 := low3(t.MicrosPerBeat)
+		raw := []byte{0xFF, 0x51, 0x03, u24[0], u24[1], u24[2]}
+		kept = append(kept, trackEvent{tick: t.Tick, raw: raw})
+	}
+	// End of track stays last, moving later if needed.
+	var last uint32
+	for _, e := range kept {
+		if !e.isEndOfTrack() && e.tick > last {
+			last = e.tick
+		}
+	}
+	for i := range kept {
+		if kept[i].isEndOfTrack() && kept[i].tick < last {
+			kept[i].tick = last
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		if kept[i].tick != kept[j].tick {
+			return kept[i].tick < kept[j].tick
+		}
+		return !kept[i].isEndOfTrack() && kept[j].isEndOfTrack()
+	})
+	trk := encodeTrack(kept)
+	out = make([]byte, 0, len(data)+len(trk))
+	out = append(out, data[:c.start-4]...)
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(trk)))
+	out = append(out, length...)
+	out = append(out, trk...)
+	out = append(out, data[c.end:]...)
+	return
+}
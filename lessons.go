@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/Michael-F-Ellis/goht" // dot import makes sense here
+)
+
+// lesson is a curriculum of etude steps loaded from a JSON file. The lesson
+// name is the file name without its ".json" extension.
+type lesson struct {
+	Name        string       `json:"-"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Steps       []lessonStep `json:"steps"`
+}
+
+// lessonStep describes the etudes to practice at one step of a lesson and
+// when the student is ready to move on.
+type lessonStep struct {
+	Title        string      `json:"title"`
+	Notes        string      `json:"notes"`
+	Pattern      string      `json:"pattern"`
+	TonalCenter  string      `json:"tonalCenter"` // for allintervals
+	Intervals    []string    `json:"intervals"`   // for the interval patterns
+	Progression  string      `json:"progression"` // for changes, default "I-IV-V-I"
+	Metronome    string      `json:"metronome"`   // default "on"
+	TempoMin     int         `json:"tempoMin"`
+	TempoMax     int         `json:"tempoMax"`
+	Repeats      int         `json:"repeats"`
+	Silent       int         `json:"silent"`
+	Mute         string      `json:"mute"` // mute pattern or "random", overrides silent
+	Call         string      `json:"call"` // GM sound for the first bar of each pattern
+	Dynamics     string      `json:"dynamics"`
+	Articulation string      `json:"articulation"`
+	Tuning       string      `json:"tuning"`
+	Direction    string      `json:"direction"`
+	Cell         string      `json:"cell"`
+	Steps        int         `json:"steps"`
+	MaxLeap      string      `json:"maxLeap"`
+	SetClass     string      `json:"setClass"`
+	Row          string      `json:"row"`
+	RowForms     string      `json:"rowForms"`
+	Register     string      `json:"register"`
+	Position     int         `json:"position"`
+	Strings      string      `json:"strings"`
+	Syllables    string      `json:"syllables"`
+	CountIn      string      `json:"countIn"`
+	Markers      bool        `json:"markers"`
+	Advance      advancement `json:"advance"`
+}
+
+// advancement is the criteria for moving past a lesson step: at least
+// Etudes etudes played at Tempo or faster, spread over at least Days
+// different days.
+type advancement struct {
+	Etudes int `json:"etudes"`
+	Tempo  int `json:"tempo"`
+	Days   int `json:"days"`
+}
+
+// lessonsPath is the directory holding lesson files. An empty string
+// disables lessons.
+var lessonsPath string
+
+// lessons maps lesson names to lessons loaded at startup.
+var lessons = map[string]*lesson{}
+
+// loadLessons reads all the lesson files in dir. It returns an error if a
+// file can't be parsed or describes an invalid etude.
+func loadLessons(dir string) (loaded map[string]*lesson, err error) {
+	fnames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return
+	}
+	loaded = map[string]*lesson{}
+	for _, fname := range fnames {
+		var l *lesson
+		l, err = readLesson(fname)
+		if err != nil {
+			return
+		}
+		loaded[l.Name] = l
+	}
+	return
+}
+
+// readLesson reads and validates one lesson file.
+func readLesson(fname string) (l *lesson, err error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return
+	}
+	l = &lesson{Name: strings.TrimSuffix(filepath.Base(fname), ".json")}
+	if err = json.Unmarshal(data, l); err != nil {
+		err = fmt.Errorf("%s: %v", fname, err)
+		return
+	}
+	if len(l.Steps) == 0 {
+		err = fmt.Errorf("%s: lesson has no steps", fname)
+		return
+	}
+	for i := range l.Steps {
+		step := &l.Steps[i]
+		if step.Metronome == "" {
+			step.Metronome = "on"
+		}
+		if step.TempoMax == 0 {
+			step.TempoMax = step.TempoMin
+		}
+		if step.TempoMin < 20 || step.TempoMax < step.TempoMin {
+			err = fmt.Errorf("%s: step %d: bad tempo range %d-%d", fname, i+1, step.TempoMin, step.TempoMax)
+			return
+		}
+		req := step.etudeRequest("acoustic_grand_piano", step.TempoMin, "")
+		if !validEtudeRequest(req) {
+			err = fmt.Errorf("%s: step %d: invalid etude %+v", fname, i+1, *step)
+			return
+		}
+	}
+	return
+}
+
+// tempos returns the tempos a student may choose for the step.
+func (step *lessonStep) tempos() (bpms []int) {
+	for bpm := step.TempoMin; bpm <= step.TempoMax; bpm += 4 {
+		bpms = append(bpms, bpm)
+	}
+	return
+}
+
+// etudeRequest returns a request for an etude built from the step.
+func (step *lessonStep) etudeRequest(instrument string, tempo int, user string) (req etudeRequest) {
+	req = etudeRequest{
+		tonalCenter: step.TonalCenter,
+		pattern:     step.Pattern,
+		instrument:  instrument,
+		tempo:       strconv.Itoa(tempo),
+		repeats:     step.Repeats,
+		silent:      step.Silent,
+		mute:        step.Mute,
+		user:        user,
+	}
+	if req.tonalCenter == "random" {
+		etudeMutex.Lock() // rng is shared with etude generation
+		req.tonalCenter = keyNames[rng.Intn(len(keyNames))]
+		etudeMutex.Unlock()
+	}
+	intervals := []*string{&req.interval1, &req.interval2, &req.interval3}
+	for i, name := range step.Intervals {
+		if i < len(intervals) {
+			*intervals[i] = name
+		}
+	}
+	parseMetronome(step.Metronome, &req)
+	q := url.Values{}
+	if step.Progression != "" {
+		q.Set("progression", step.Progression)
+	}
+	if step.Call != "" {
+		q.Set("call", step.Call)
+	}
+	if step.Dynamics != "" {
+		q.Set("dynamics", step.Dynamics)
+	}
+	if step.Articulation != "" {
+		q.Set("articulation", step.Articulation)
+	}
+	if step.Tuning != "" {
+		q.Set("tuning", step.Tuning)
+	}
+	if step.Direction != "" {
+		q.Set("direction", step.Direction)
+	}
+	if step.Cell != "" {
+		q.Set("cell", step.Cell)
+	}
+	if step.Position != 0 {
+		q.Set("position", strconv.Itoa(step.Position))
+	}
+	if step.Markers {
+		q.Set("markers", "on")
+	}
+	if step.Steps != 0 {
+		q.Set("steps", strconv.Itoa(step.Steps))
+	}
+	if step.MaxLeap != "" {
+		q.Set("maxleap", step.MaxLeap)
+	}
+	for name, v := range map[string]string{
+		"setclass": step.SetClass, "row": step.Row, "rowforms": step.RowForms,
+		"register": step.Register, "strings": step.Strings, "syllables": step.Syllables,
+		"countin": step.CountIn,
+	} {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	if err := parseEtudeOptions(q, &req); err != nil {
+		log.Printf("bad options for lesson step %q: %v", step.Title, err)
+	}
+	return
+}
+
+// progress returns the number of qualifying etudes and days in recs for the
+// step's advancement criteria and whether the criteria are met.
+func (step *lessonStep) progress(recs []practiceRecord) (etudes, days int, done bool) {
+	req := step.etudeRequest("", step.TempoMin, "")
+	want := strings.Join(requestIntervals(&req), "-")
+	seen := map[string]bool{}
+	for _, rec := range recs {
+		if rec.Pattern != step.Pattern || strings.Join(rec.Intervals, "-") != want {
+			continue
+		}
+		if step.Pattern == "allintervals" && step.TonalCenter != "random" && rec.TonalCenter != step.TonalCenter {
+			continue
+		}
+		if rec.Tempo < step.Advance.Tempo {
+			continue
+		}
+		etudes++
+		seen[rec.Time.Format("2006-01-02")] = true
+	}
+	days = len(seen)
+	done = etudes >= step.Advance.Etudes && days >= step.Advance.Days
+	return
+}
+
+// lessonHndlr serves lesson pages and etudes. The patterns are
+//
+//	/lesson/                      list of lessons
+//	/lesson/<name>                list of the lesson's steps
+//	/lesson/<name>/<step>         page for a step (numbered from 1)
+//	/lesson/<name>/<step>/etude/<instrument>/<tempo>
+//	                              etude built from the step
+//
+// An optional user query parameter shows the user's progress on step pages
+// and records etudes in the user's history.
+func lessonHndlr(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	user := r.URL.Query().Get("user")
+	if user != "" && !validUserName(user) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(path) == 2 {
+		servePage(w, lessonsPage())
+		return
+	}
+	l, ok := lessons[path[2]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(path) == 3 {
+		servePage(w, lessonPage(l))
+		return
+	}
+	n, err := strconv.Atoi(path[3])
+	if err != nil || n < 1 || n > len(l.Steps) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	step := &l.Steps[n-1]
+	switch {
+	case len(path) == 4:
+		var recs []practiceRecord
+		if user != "" && history != nil {
+			recs, _ = history.records(user) // unknown users have no progress
+		}
+		servePage(w, lessonStepPage(l, n, user, recs))
+	case len(path) == 7 && path[4] == "etude":
+		tempo, err := strconv.Atoi(path[6])
+		if err != nil || tempo < step.TempoMin || tempo > step.TempoMax {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		serveEtude(w, r, step.etudeRequest(path[5], tempo, user))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// servePage renders page as the response.
+func servePage(w http.ResponseWriter, page *HtmlTree) {
+	var buf bytes.Buffer
+	if err := Render(page, &buf, 0); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("could not render page: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// lessonHead returns the <head> element shared by the lesson pages.
+func lessonHead(title string) *HtmlTree {
+	return Head("",
+		Meta(`name="viewport" content="width=device-width, initial-scale=1"`),
+		Title("", title),
+		indexCSS(),
+		lessonJS(),
+		playerJS(),
+	)
+}
+
+// lessonsPage lists the available lessons.
+func lessonsPage() *HtmlTree {
+	var names []string
+	for name := range lessons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var items []interface{}
+	for _, name := range names {
+		href := fmt.Sprintf(`href="/lesson/%s"`, name)
+		items = append(items, Li("", A(href, lessons[name].Title)))
+	}
+	return Html("", lessonHead("Lessons"), Body("",
+		H2("class=title", "Lessons"),
+		Ul("", items...),
+		P("", A(`href="/"`, "Infinite Etudes")),
+	))
+}
+
+// lessonPage lists the steps of a lesson.
+func lessonPage(l *lesson) *HtmlTree {
+	var items []interface{}
+	for i, step := range l.Steps {
+		href := fmt.Sprintf(`href="/lesson/%s/%d"`, l.Name, i+1)
+		items = append(items, Li("", A(href, step.Title)))
+	}
+	return Html("", lessonHead(l.Title), Body("",
+		H2("class=title", l.Title),
+		P("", l.Description),
+		Ol("", items...),
+		P("", A(`href="/lesson/"`, "All lessons")),
+	))
+}
+
+// lessonStepPage shows one step with controls for playing its etudes and,
+// if user is not empty, the user's progress toward advancing.
+func lessonStepPage(l *lesson, n int, user string, recs []practiceRecord) *HtmlTree {
+	step := &l.Steps[n-1]
+	var sounds []interface{}
+	for _, iinfo := range supportedInstruments {
+		value := fmt.Sprintf(`value="%s"`, iinfo.name)
+		sounds = append(sounds, Option(value, iinfo.displayName))
+	}
+	var tempos []interface{}
+	for _, bpm := range step.tempos() {
+		tempos = append(tempos, Option(fmt.Sprintf(`value="%d"`, bpm), strconv.Itoa(bpm)))
+	}
+	base := fmt.Sprintf("/lesson/%s/%d", l.Name, n)
+	criteria := fmt.Sprintf("Move on when you've played %d etudes at %d BPM or faster on at least %d different days.",
+		step.Advance.Etudes, step.Advance.Tempo, step.Advance.Days)
+	var progress interface{} = ""
+	if user != "" {
+		etudes, days, done := step.progress(recs)
+		msg := fmt.Sprintf("%s, you've played %d qualifying etudes on %d days.", user, etudes, days)
+		if done {
+			msg += " You're ready for the next step!"
+		}
+		progress = P("", Strong("", msg))
+	}
+	nav := []interface{}{A(fmt.Sprintf(`href="/lesson/%s"`, l.Name), "All steps")}
+	if n > 1 {
+		nav = append(nav, " | ", A(fmt.Sprintf(`href="/lesson/%s/%d"`, l.Name, n-1), "Previous"))
+	}
+	if n < len(l.Steps) {
+		nav = append(nav, " | ", A(fmt.Sprintf(`href="/lesson/%s/%d"`, l.Name, n+1), "Next"))
+	}
+	return Html("", lessonHead(step.Title), Body(fmt.Sprintf(`data-base="%s" data-user="%s"`, base, user),
+		H2("class=title", l.Title),
+		H3("", fmt.Sprintf("Step %d: %s", n, step.Title)),
+		P("", step.Notes),
+		P("", criteria),
+		progress,
+		Div(`class="Row"`,
+			Div(`class="Column"`, Label(``, "Instrument", Select("id=sound-select", sounds...))),
+			Div(`class="Column"`, Label(``, "Tempo", Select("id=tempo-select", tempos...))),
+		),
+		Div(`style="padding-top:1vh;"`,
+			Button(`onclick="playStart()"`, "Play"),
+			Button(`onclick="playStop()"`, "Stop"),
+		),
+		P("", nav...),
+	))
+}
+
+// lessonJS returns the script for lesson step pages. Etudes play in the
+// Web Audio player from playerJS.
+func lessonJS() *HtmlTree {
+	return Script("", `
+		function lessonEtudeURL() {
+		  var base = document.body.dataset.base
+		  var sound = document.getElementById("sound-select").value
+		  var tempo = document.getElementById("tempo-select").value
+		  var url = base + "/etude/" + sound + "/" + tempo
+		  var user = document.body.dataset.user
+		  if (user != "") {
+			  url += "?user=" + encodeURIComponent(user)
+		  }
+		  return url
+		}
+		function playStart() {
+		  stopEvents()
+		  fetch(lessonEtudeURL())
+			.then(function(resp) {
+			  if (!resp.ok) {
+				throw new Error("etude request failed: " + resp.status)
+			  }
+			  return fetch("/events/" + resp.headers.get("X-Etude-Seed"))
+			})
+			.then(function(resp) {
+			  if (!resp.ok) {
+				throw new Error("event list request failed: " + resp.status)
+			  }
+			  return resp.json()
+			})
+			.then(function(events) {
+			  var end = events.starts[events.starts.length - 1]
+			  playEvents(events, [{from: 0, to: end}], false, null)
+			})
+			.catch(function(err) {
+			  alert(err.message)
+			})
+		}
+		function playStop() {
+		  stopEvents()
+		}
+	`)
+}
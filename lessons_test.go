@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadLessons(t *testing.T) {
+	wd, _ := os.Getwd()
+	if filepath.Base(wd) == "test" { // TestMain changes to the test directory
+		wd = filepath.Dir(wd)
+	}
+	loaded, err := loadLessons(filepath.Join(wd, "lessons"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, name := range []string{"beginner", "serious"} {
+		if _, ok := loaded[name]; !ok {
+			t.Errorf("expected lesson %s to be loaded", name)
+		}
+	}
+	// one step per pattern listed in forTheSerious
+	if n := len(loaded["serious"].Steps); n != 1+18+36 {
+		t.Errorf("expected 55 steps in serious lesson, got %d", n)
+	}
+}
+
+func TestReadBadLesson(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lessons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bad := map[string]string{
+		"nosteps.json":  `{"title": "x", "steps": []}`,
+		"badtempo.json": `{"title": "x", "steps": [{"pattern": "interval", "intervals": ["minor3"], "tempoMin": 120, "tempoMax": 60}]}`,
+		"badetude.json": `{"title": "x", "steps": [{"pattern": "interval", "intervals": ["minor33"], "tempoMin": 60}]}`,
+		"badjson.json":  `{"title": `,
+	}
+	for name, content := range bad {
+		fname := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readLesson(fname); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRandomTonalCenter(t *testing.T) {
+	// a random tonal center comes from rng, so a seed reproduces it
+	step := lessonStep{TonalCenter: "random", Pattern: "allintervals", Metronome: "on"}
+	var centers []string
+	for i := 0; i < 2; i++ {
+		etudeMutex.Lock()
+		seedEtudeRandom(42)
+		etudeMutex.Unlock()
+		centers = append(centers, step.etudeRequest("viola", 100, "").tonalCenter)
+	}
+	if centers[0] != centers[1] || !validKeyName(centers[0]) {
+		t.Errorf("expected the same tonal center from the same seed, got %v", centers)
+	}
+}
+
+func TestLessonStepProgress(t *testing.T) {
+	step := lessonStep{
+		Pattern:   "intervalpair",
+		Intervals: []string{"major3", "minor3"},
+		Metronome: "on",
+		TempoMin:  80,
+		TempoMax:  160,
+		Advance:   advancement{Etudes: 2, Tempo: 120, Days: 2},
+	}
+	req := step.etudeRequest("viola", 100, "mike")
+	if req.interval1 != "major3" || req.interval2 != "minor3" || req.tempo != "100" || req.user != "mike" {
+		t.Errorf("unexpected request %+v", req)
+	}
+	day := func(d int) time.Time { return time.Date(2020, 11, d, 12, 0, 0, 0, time.UTC) }
+	recs := []practiceRecord{
+		{Time: day(1), Pattern: "intervalpair", Intervals: []string{"major3", "minor3"}, Tempo: 120},
+		{Time: day(1), Pattern: "intervalpair", Intervals: []string{"major3", "minor3"}, Tempo: 100}, // too slow
+		{Time: day(2), Pattern: "intervalpair", Intervals: []string{"minor3", "major3"}, Tempo: 160}, // wrong intervals
+	}
+	etudes, days, done := step.progress(recs)
+	if etudes != 1 || days != 1 || done {
+		t.Errorf("expected 1 etude on 1 day, not done, got %d %d %v", etudes, days, done)
+	}
+	recs = append(recs, practiceRecord{Time: day(3), Pattern: "intervalpair", Intervals: []string{"major3", "minor3"}, Tempo: 140})
+	if _, _, done = step.progress(recs); !done {
+		t.Errorf("expected step to be done")
+	}
+}
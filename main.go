@@ -79,13 +79,17 @@ func main() {
 	flag.StringVar(&imgPath, "g", filepath.Join(userHomeDir(), "go", "src", "github.com", "Michael-F-Ellis", "infinite-etudes", "img"), "Path to img files on your host (server-mode only)")
 
 	var midijsPath string
-	flag.StringVar(&midijsPath, "m", filepath.Join(userHomeDir(), "go", "src", "github.com", "Michael-F-Ellis", "infinite-etudes", "midijs"), "Path to midijs files on your host (server-mode only)")
+	flag.StringVar(&midijsPath, "m", filepath.Join(userHomeDir(), "go", "src", "github.com", "Michael-F-Ellis", "infinite-etudes", "midijs"), "Path to optional midijs files on your host (server-mode only)")
+
+	flag.StringVar(&lessonsPath, "c", filepath.Join(userHomeDir(), "go", "src", "github.com", "Michael-F-Ellis", "infinite-etudes", "lessons"), "Path to curriculum (lesson) files on your host. Empty string disables lessons (server-mode only)")
 
 	var hostport string
 	flag.StringVar(&hostport, "p", "localhost:8080", "hostname (or IP) and port to serve on. (server-mode only)")
 
 	flag.IntVar(&expireSeconds, "x", 10, "Maximum age in seconds for generated files (server-mode only)")
 
+	flag.StringVar(&historyPath, "d", "", "Path to the practice history database. History is only recorded if set (server-mode only)")
+
 	// make sure all flags are defined before calling this
 	flag.Parse()
 
@@ -108,40 +112,75 @@ func validDirPath(path string) (err error) {
 
 // usage extends the flag package's default help message.
 func usage() {
-	fmt.Println(copyright)
+	fmt.Print(copyright)
 	fmt.Printf("Usage: etudes [OPTIONS]\n  -h    print this help message.\n")
 	flag.PrintDefaults()
-	fmt.Println(description)
+	fmt.Print(description)
 
 }
 
-// mkRequestedEtude creates the requested etude in the current directory. The
-// arguments are assumed to be previously vetted and are not checked.
-func mkRequestedEtude(midilo, midihi, tempo, instrument int, r etudeRequest) {
+// mkRequestedEtude creates the requested etude in the current directory and
+// returns the sequence it was made from. The arguments are assumed to be
+// previously vetted and are not checked.
+func mkRequestedEtude(midilo, midihi, tempo, instrument int, r etudeRequest) (s etudeSequence) {
 	iname := r.instrument
 	switch r.pattern {
 	case "allintervals":
-		s := generateIntervalSequence(midilo, midihi, tempo, instrument, r)
+		s = generateIntervalSequence(midilo, midihi, tempo, instrument, r)
 		mkMidi(&s, true)
 	case "interval":
-		s := generateEqualIntervalSequence(midilo, midihi, tempo, instrument, r)
+		s = generateEqualIntervalSequence(midilo, midihi, tempo, instrument, r)
+		orientPatterns(&s)
 		mkMidi(&s, true)
 	case "intervalpair":
 		i1 := intervalSizeByName(r.interval1)
 		i2 := intervalSizeByName(r.interval2)
-		s := generateTwoIntervalSequence(midilo, midihi, tempo, instrument, iname, i1, i2)
+		s = generateTwoIntervalSequence(midilo, midihi, tempo, instrument, iname, i1, i2)
 		s.req = r
+		orientPatterns(&s)
 		mkMidi(&s, true) // no tighten
 	case "intervaltriple":
 		i1 := intervalSizeByName(r.interval1)
 		i2 := intervalSizeByName(r.interval2)
 		i3 := intervalSizeByName(r.interval3)
-		s := generateThreeIntervalSequence(midilo, midihi, tempo, instrument, iname, i1, i2, i3)
+		s = generateThreeIntervalSequence(midilo, midihi, tempo, instrument, iname, i1, i2, i3)
 		s.req = r
+		orientPatterns(&s)
+		mkMidi(&s, true) // no tighten
+	case "intervalcell":
+		s = generateCellSequence(midilo, midihi, tempo, instrument, r)
+		orientPatterns(&s)
 		mkMidi(&s, true) // no tighten
+	case "adaptive":
+		var cards []reviewCard
+		if history != nil {
+			var err error
+			cards, err = history.cards(r.user)
+			if err != nil {
+				log.Printf("could not read review cards for %s: %v", r.user, err)
+			}
+		}
+		s = generateAdaptiveSequence(midilo, midihi, tempo, instrument, r, cards, time.Now())
+		mkMidi(&s, true)
+	case "changes":
+		s = generateChangesSequence(midilo, midihi, tempo, instrument, r)
+		mkMidi(&s, true)
+	case "melody":
+		s = generateMelodySequence(midilo, midihi, tempo, instrument, r)
+		mkMidi(&s, true) // keep the melodic leaps
+	case "setclass":
+		s = generateSetClassSequence(midilo, midihi, tempo, instrument, r)
+		mkMidi(&s, true)
+	case "tonerow":
+		s = generateToneRowSequence(midilo, midihi, tempo, instrument, r)
+		mkMidi(&s, true)
 	default:
 		panic(fmt.Sprintf("%s is not a supported etude pattern", r.pattern))
 	}
+	if err := checkCoverage(&s); err != nil {
+		log.Printf("%s etude doesn't have its promised coverage: %v", r.pattern, err)
+	}
+	return
 }
 
 // iToBools converts the first length bits of v to
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Count-in choices for etudeRequest.countIn. The default, "", is one bar of
+// clicks.
+const (
+	countInTwoBars = "2"        // two bars of clicks
+	countInReadyGo = "ready-go" // half notes, then a bar of quarters: 1, 2, 1, 2, ready, go
+)
+
+// Meta event types used for cues in the tempo track.
+const (
+	metaText   = 0x01
+	metaLyric  = 0x05
+	metaMarker = 0x06
+)
+
+// validCountIn returns true if req.countIn is a supported count-in.
+func validCountIn(req etudeRequest) bool {
+	switch req.countIn {
+	case "", countInTwoBars, countInReadyGo:
+		return true
+	}
+	return false
+}
+
+// countInBars returns the number of bars before the first pattern.
+func countInBars(req *etudeRequest) int {
+	switch req.countIn {
+	case countInTwoBars, countInReadyGo:
+		return 2
+	}
+	return 1
+}
+
+// countInTicks returns the length of the count-in in ticks.
+func countInTicks(req *etudeRequest) uint32 {
+	return uint32(countInBars(req) * ticksPerBar)
+}
+
+// countInMusic returns metronome track data for the count-in requested by
+// req. It always clicks, even if the metronome is off for the etude.
+func countInMusic(req *etudeRequest) []byte {
+	on := etudeRequest{metronome: metronomeOn, metroSound: req.metroSound}
+	if req.countIn != countInReadyGo {
+		return metronomeBars(countInBars(req), &on).Bytes()
+	}
+	sound, _ := metronomeSoundByName(req.metroSound)
+	buf := new(bytes.Buffer)
+	for _, pitch := range []byte{sound.accent, sound.other} {
+		buf.Write([]byte{0x99, pitch, 0x30})
+		buf.Write(varLen(2 * ticksPerBeat))
+		buf.Write([]byte{0x89, pitch, 0x30, 0x00})
+	}
+	buf.Write(metronomeBars(1, &on).Bytes())
+	return buf.Bytes()
+}
+
+// countInWords returns text events counting off the count-in requested by
+// req, one for each click.
+func countInWords(req *etudeRequest) (events []midiEvent) {
+	if req.countIn == countInReadyGo {
+		for i, w := range []string{"1", "2"} {
+			events = append(events, midiEvent{uint32(2 * i * ticksPerBeat), metaEvent(metaText, w)})
+		}
+		for i, w := range []string{"1", "2", "ready", "go"} {
+			events = append(events, midiEvent{uint32(ticksPerBar + i*ticksPerBeat), metaEvent(metaText, w)})
+		}
+		return
+	}
+	for bar := 0; bar < countInBars(req); bar++ {
+		for beat := 0; beat < 4; beat++ {
+			events = append(events, midiEvent{uint32(bar*ticksPerBar + beat*ticksPerBeat), metaEvent(metaText, strconv.Itoa(beat+1))})
+		}
+	}
+	return
+}
+
+// patternStartTicks returns the tick at which each pattern of sequence
+// begins.
+func patternStartTicks(sequence *etudeSequence) (ticks []uint32) {
+	tick := countInTicks(&sequence.req)
+	nbars := 1 + sequence.req.repeats
+	for _, t := range sequence.seq {
+		ticks = append(ticks, tick)
+		tick += uint32(nbars * cellBars(len(t)) * ticksPerBar)
+	}
+	return
+}
+
+// patternContent describes the intervals between the notes of ptn, e.g.
+// "up major3, down minor3".
+func patternContent(ptn midiPattern) string {
+	var steps []string
+	for i := 1; i < len(ptn); i++ {
+		size := ptn[i] - ptn[i-1]
+		dir := "up"
+		if size < 0 {
+			dir, size = "down", -size
+		}
+		name := fmt.Sprintf("%d half steps", size)
+		for _, v := range intervalInfo {
+			if v.size == size {
+				name = v.fileName
+			}
+		}
+		if size == 0 {
+			steps = append(steps, name)
+			continue
+		}
+		steps = append(steps, dir+" "+name)
+	}
+	return strings.Join(steps, ", ")
+}
+
+// cueEvents returns the meta events for the tempo track of sequence: if
+// req.markers is set, a text event for each count of the count-in and, at
+// the start of each pattern, a marker naming it and a text event with its
+// intervals.
+func cueEvents(sequence *etudeSequence) (events []midiEvent) {
+	if !sequence.req.markers {
+		return
+	}
+	events = countInWords(&sequence.req)
+	for i, tick := range patternStartTicks(sequence) {
+		events = append(events,
+			midiEvent{tick, metaEvent(metaMarker, fmt.Sprintf("Pattern %d", i+1))},
+			midiEvent{tick, metaEvent(metaText, patternContent(sequence.seq[i]))})
+	}
+	return
+}
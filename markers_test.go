@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Michael-F-Ellis/infinite-etudes/internal/miditempo"
+)
+
+func TestCountIn(t *testing.T) {
+	for _, test := range []struct {
+		countIn string
+		bars    int
+		clicks  int
+	}{{"", 1, 4}, {countInTwoBars, 2, 8}, {countInReadyGo, 2, 6}} {
+		req := etudeRequest{countIn: test.countIn}
+		if n := countInBars(&req); n != test.bars {
+			t.Errorf("%q: expected %d bars, got %d", test.countIn, test.bars, n)
+		}
+		x := countInMusic(&req)
+		if n := bytes.Count(x, []byte{0x99}); n != test.clicks {
+			t.Errorf("%q: expected %d clicks, got %d", test.countIn, test.clicks, n)
+		}
+		if n := len(countInWords(&req)); n != test.clicks {
+			t.Errorf("%q: expected %d words, got %d", test.countIn, test.clicks, n)
+		}
+	}
+	// two half note clicks, then a bar of quarters
+	x := countInMusic(&etudeRequest{countIn: countInReadyGo})
+	exp := []byte{0x99, 76, 0x30, 0x8f, 0x00, 0x89, 76, 0x30, 0x00, 0x99, 77, 0x30, 0x8f, 0x00, 0x89, 77, 0x30, 0x00}
+	if !bytes.HasPrefix(x, exp) {
+		t.Errorf("expected % x, got % x", exp, x[:len(exp)])
+	}
+}
+
+func TestPatternContent(t *testing.T) {
+	tests := []struct {
+		ptn midiPattern
+		exp string
+	}{
+		{midiPattern{60, 64, 61}, "up major3, down minor3"},
+		{midiPattern{60, 60, 81, 50}, "unison, up major13, down 31 half steps"},
+	}
+	for _, test := range tests {
+		if got := patternContent(test.ptn); got != test.exp {
+			t.Errorf("%v: expected %q, got %q", test.ptn, test.exp, got)
+		}
+	}
+}
+
+func TestPatternStartTicks(t *testing.T) {
+	s := etudeSequence{
+		seq: []midiPattern{{60, 64, 61}, {60, 62, 64, 65, 67}, {60, 64, 61}},
+		req: etudeRequest{repeats: 2, countIn: countInTwoBars},
+	}
+	exp := []uint32{2 * ticksPerBar, 5 * ticksPerBar, 11 * ticksPerBar}
+	if got := patternStartTicks(&s); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestMarkers(t *testing.T) {
+	req := etudeRequest{pattern: "interval", interval1: "major3", tempo: "120", repeats: 1, countIn: countInReadyGo, markers: true}
+	s := generateEqualIntervalSequence(48, 84, 120, 0, req)
+	mkMidi(&s, true)
+	defer os.Remove(s.filename)
+	for _, e := range []midiEvent{
+		{0, metaEvent(metaText, "ready")},
+		{0, metaEvent(metaMarker, "Pattern 1")},
+		{0, metaEvent(metaMarker, "Pattern 12")},
+		{0, metaEvent(metaText, patternContent(s.seq[11]))},
+	} {
+		if !bytes.Contains(s.midi, e.data) {
+			t.Errorf("expected % x in the midi file", e.data)
+		}
+	}
+	// the first pattern waits for the two bar count-in
+	if !bytes.Contains(s.midi, append(varLen(2*ticksPerBar), 0x90)) {
+		t.Errorf("expected the first note after two bars")
+	}
+	if _, err := miditempo.GetTempoMap(s.midi); err != nil {
+		t.Errorf("could not read the tempo map: %v", err)
+	}
+	if secs := etudeSeconds(&req, 12); secs != float64((2+12*2)*4*60)/120 {
+		t.Errorf("expected the count-in in the playing time, got %v", secs)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Random Melody etudes are short melodies in the key of the tonal center for
+// melodic dictation and sight singing practice.
+const (
+	melodyPatterns = 12 // melodies in an etude
+	melodyNotes    = 8  // notes in each melody, two bars at one note per beat
+	maxMelodySpan  = 19 // widest melody in half steps, an octave and a fifth
+)
+
+// Melody style defaults used when a Random Melody request doesn't specify
+// them.
+const (
+	defaultStepPercent = 70
+	defaultMaxLeap     = "perfect5"
+)
+
+// majorSteps are the half steps above the tonic of each degree of a major
+// scale.
+var majorSteps = []int{0, 2, 4, 5, 7, 9, 11}
+
+// degreePitch returns the pitch of the 0-indexed scale degree d in the major
+// key whose tonic is keynum. Degrees below 0 or above 6 are in the octaves
+// below or above.
+func degreePitch(keynum, d int) int {
+	octave := d / 7
+	if d < 0 && d%7 != 0 {
+		octave--
+	}
+	return keynum + 12*octave + majorSteps[d-7*octave]
+}
+
+// scaleDegree returns d reduced to the range 0 to 6.
+func scaleDegree(d int) int {
+	return (d%7 + 7) % 7
+}
+
+// melodyTries is the number of random melodies randomMelody drafts before
+// falling back to a stepwise one.
+const melodyTries = 1000
+
+// randomMelody returns the scale degrees of a melody of n notes that follows
+// these rules:
+//
+//   - it starts on the tonic, third or fifth and ends on the tonic
+//   - about stepPercent of the moves are steps, the others are leaps no
+//     larger than maxLeap half steps
+//   - a leap larger than a third is followed by a step the other way
+//   - the leading tone rises to the tonic and the fourth falls to the third
+//   - it spans no more than span half steps
+//
+// Drafts whose last move to the tonic, or any other move, would break a
+// rule are drawn again.
+func randomMelody(keynum, n, stepPercent, maxLeap, span int) []int {
+	for i := 0; i < melodyTries; i++ {
+		degrees := melodyDraft(keynum, n-1, stepPercent, maxLeap, span)
+		last := degrees[len(degrees)-1]
+		tonics := []int{last - scaleDegree(last), last - scaleDegree(last) + 7}
+		if scaleDegree(last) > 3 {
+			tonics[0], tonics[1] = tonics[1], tonics[0] // nearest first
+		}
+		for _, tonic := range tonics {
+			melody := append(degrees, tonic)
+			if followsMelodyRules(keynum, melody, maxLeap, span) {
+				return melody
+			}
+		}
+	}
+	return stepwiseMelody(n)
+}
+
+// melodyDraft returns the scale degrees of the first n notes of a melody
+// for randomMelody. It tries to follow the rules, but the leading tone,
+// the fourth, maxLeap and span can override a leap's recovery.
+func melodyDraft(keynum, n, stepPercent, maxLeap, span int) (degrees []int) {
+	d := []int{0, 2, 4}[rng.Intn(3)]
+	degrees = append(degrees, d)
+	lo, hi := degreePitch(keynum, d), degreePitch(keynum, d)
+	for len(degrees) < n {
+		prev := d
+		move := 1 // in scale degrees
+		if rng.Intn(100) >= stepPercent {
+			move = 2 + rng.Intn(6) // a third up to an octave
+		}
+		if flip() {
+			move = -move
+		}
+		if len(degrees) > 1 {
+			last := degrees[len(degrees)-1] - degrees[len(degrees)-2]
+			if last > 2 || last < -2 {
+				// recover from a wide leap by step in the other direction
+				move = 1
+				if last > 0 {
+					move = -1
+				}
+			}
+		}
+		switch scaleDegree(prev) {
+		case 6: // leading tone
+			move = 1
+		case 3: // fourth
+			move = -1
+		}
+		d = prev + move
+		p := degreePitch(keynum, d)
+		size := p - degreePitch(keynum, prev)
+		if size < 0 {
+			size = -size
+		}
+		if size > maxLeap { // too wide, so step instead
+			d = prev + 1
+			if move < 0 {
+				d = prev - 1
+			}
+			p = degreePitch(keynum, d)
+		}
+		if p < lo && hi-p > span || p > hi && p-lo > span {
+			d = prev - (d - prev) // turn around to stay within the span
+			p = degreePitch(keynum, d)
+		}
+		if p < lo {
+			lo = p
+		}
+		if p > hi {
+			hi = p
+		}
+		degrees = append(degrees, d)
+	}
+	return
+}
+
+// followsMelodyRules returns true if every move of the melody degrees in
+// the key whose tonic is keynum follows the rules of randomMelody for
+// leaps, their recovery, the leading tone and the fourth, and the melody
+// spans no more than span half steps.
+func followsMelodyRules(keynum int, degrees []int, maxLeap, span int) bool {
+	lo, hi := degreePitch(keynum, degrees[0]), degreePitch(keynum, degrees[0])
+	for j := 1; j < len(degrees); j++ {
+		prev, d := degrees[j-1], degrees[j]
+		p := degreePitch(keynum, d)
+		size := p - degreePitch(keynum, prev)
+		if size > maxLeap || -size > maxLeap {
+			return false
+		}
+		if j > 1 {
+			last := prev - degrees[j-2]
+			if last > 2 && d != prev-1 || last < -2 && d != prev+1 {
+				return false
+			}
+		}
+		switch scaleDegree(prev) {
+		case 6:
+			if d != prev+1 {
+				return false
+			}
+		case 3:
+			if d != prev-1 {
+				return false
+			}
+		}
+		if p < lo {
+			lo = p
+		}
+		if p > hi {
+			hi = p
+		}
+	}
+	return hi-lo <= span
+}
+
+// stepwiseMelody returns the scale degrees of a melody of n notes, at
+// least 3, that moves only by step and spans no more than a fourth: do re
+// do ... do for an odd n, otherwise sol la sol ... la ti do.
+func stepwiseMelody(n int) (degrees []int) {
+	if n%2 == 1 {
+		for i := 0; i < n; i++ {
+			degrees = append(degrees, i%2)
+		}
+		return
+	}
+	for i := 0; i < n-4; i++ {
+		degrees = append(degrees, 4+i%2)
+	}
+	return append(degrees, 4, 5, 6, 7)
+}
+
+// generateMelodySequence returns an etudeSequence of melodyPatterns random
+// melodies in the key of req.tonalCenter following the rules of
+// randomMelody. The melodies are limited to a span that mkMidi can always
+// fit between midilo and midihi.
+func generateMelodySequence(midilo int, midihi int, tempo int, instrument int, req etudeRequest) (sequence etudeSequence) {
+	keynum := -1
+	for i, v := range keyNames {
+		if v == req.tonalCenter {
+			keynum = i
+		}
+	}
+	if keynum == -1 {
+		panic(fmt.Sprintf("%s is not a supported pitchname", req.tonalCenter))
+	}
+	span := maxMelodySpan
+	if midihi-midilo-11 < span {
+		span = midihi - midilo - 11 // leave room to shift by octaves
+	}
+	sequence = etudeSequence{
+		midilo:     midilo,
+		midihi:     midihi,
+		tempo:      tempo,
+		instrument: instrument,
+		keyname:    req.tonalCenter,
+		req:        req,
+	}
+	for i := 0; i < melodyPatterns; i++ {
+		var ptn midiPattern
+		for _, d := range randomMelody(keynum, melodyNotes, req.stepPercent, intervalSizeByName(req.maxLeap), span) {
+			ptn = append(ptn, degreePitch(keynum, d))
+		}
+		sequence.seq = append(sequence.seq, ptn)
+	}
+	return
+}
+
+// validMelody returns true if the melody style settings in req are usable.
+func validMelody(req etudeRequest) bool {
+	if req.stepPercent < 0 || req.stepPercent > 100 {
+		return false
+	}
+	size := intervalSizeByName(req.maxLeap)
+	return validIntervalName(req.maxLeap) && size >= 2 && size <= 12
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDegreePitch(t *testing.T) {
+	tests := []struct{ keynum, degree, exp int }{
+		{0, 0, 0}, {0, 6, 11}, {0, 7, 12}, {0, -1, -1}, {0, -7, -12}, {0, -8, -13}, {2, 2, 6}, {2, 9, 18},
+	}
+	for _, test := range tests {
+		if got := degreePitch(test.keynum, test.degree); got != test.exp {
+			t.Errorf("degree %d in key %d: expected %d, got %d", test.degree, test.keynum, test.exp, got)
+		}
+	}
+}
+
+func TestRandomMelody(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		seedEtudeRandom(seed)
+		for _, maxLeap := range []int{2, 4, 7, 12} {
+			for _, stepPercent := range []int{0, 70, 100} {
+				for i := 0; i < 10; i++ {
+					checkMelody(t, randomMelody(0, melodyNotes, stepPercent, maxLeap, 19), maxLeap, 19)
+				}
+			}
+		}
+	}
+}
+
+// checkMelody reports any way in which degrees, a melody in C, breaks the
+// rules of randomMelody, checking every move including the last.
+func checkMelody(t *testing.T, degrees []int, maxLeap, span int) {
+	t.Helper()
+	if len(degrees) != melodyNotes {
+		t.Fatalf("expected %d notes, got %v", melodyNotes, degrees)
+	}
+	if s := scaleDegree(degrees[0]); s != 0 && s != 2 && s != 4 {
+		t.Errorf("%v doesn't start on the tonic, third or fifth", degrees)
+	}
+	if scaleDegree(degrees[len(degrees)-1]) != 0 {
+		t.Errorf("%v doesn't end on the tonic", degrees)
+	}
+	lo, hi := degreePitch(0, degrees[0]), degreePitch(0, degrees[0])
+	for j := 1; j < len(degrees); j++ {
+		p0, p1 := degreePitch(0, degrees[j-1]), degreePitch(0, degrees[j])
+		if p1-p0 > maxLeap || p0-p1 > maxLeap {
+			t.Errorf("%v: move %d leaps more than %d half steps", degrees, j, maxLeap)
+		}
+		if j > 1 {
+			last := degrees[j-1] - degrees[j-2]
+			if last > 2 && degrees[j] != degrees[j-1]-1 || last < -2 && degrees[j] != degrees[j-1]+1 {
+				t.Errorf("%v: move %d doesn't recover from a leap by step", degrees, j)
+			}
+		}
+		switch scaleDegree(degrees[j-1]) {
+		case 6:
+			if degrees[j] != degrees[j-1]+1 {
+				t.Errorf("%v: the leading tone doesn't rise to the tonic", degrees)
+			}
+		case 3:
+			if degrees[j] != degrees[j-1]-1 {
+				t.Errorf("%v: the fourth doesn't fall to the third", degrees)
+			}
+		}
+		if p1 < lo {
+			lo = p1
+		}
+		if p1 > hi {
+			hi = p1
+		}
+	}
+	if hi-lo > span {
+		t.Errorf("%v spans more than %d half steps", degrees, span)
+	}
+}
+
+func TestStepwiseMelody(t *testing.T) {
+	checkMelody(t, stepwiseMelody(melodyNotes), 2, 5)
+	if exp := []int{0, 1, 0, 1, 0}; !reflect.DeepEqual(stepwiseMelody(5), exp) {
+		t.Errorf("expected %v, got %v", exp, stepwiseMelody(5))
+	}
+}
+
+func TestGenerateMelodySequence(t *testing.T) {
+	req := etudeRequest{tonalCenter: "eflat", pattern: "melody", stepPercent: defaultStepPercent, maxLeap: defaultMaxLeap}
+	s := generateMelodySequence(58, 82, 120, 0, req)
+	if len(s.seq) != melodyPatterns {
+		t.Fatalf("expected %d melodies, got %d", melodyPatterns, len(s.seq))
+	}
+	mkMidi(&s, true)
+	defer os.Remove(s.filename)
+	scale := getScale(3, false)
+	for _, ptn := range s.seq {
+		for _, p := range ptn {
+			if p < 58 || p > 82 {
+				t.Errorf("%v is out of range", ptn)
+			}
+			inKey := false
+			for _, pc := range scale {
+				inKey = inKey || p%12 == pc
+			}
+			if !inKey {
+				t.Errorf("%v has notes outside E flat major", ptn)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+// Placement strategies for etudeRequest.register. By default ("") mkMidi
+// places each pattern near the last note of the one before, a random walk
+// that can linger in one part of the instrument's range.
+const (
+	registerLow      = "low"      // the lowest third of the range
+	registerMiddle   = "middle"   // the middle third
+	registerHigh     = "high"     // the highest third
+	registerBalanced = "balanced" // an equal share of patterns in each third
+)
+
+// registerSweep is the order in which a balanced etude visits the registers.
+// It ends where it starts so an etude played in a loop moves smoothly.
+var registerSweep = []string{registerLow, registerMiddle, registerHigh, registerHigh, registerMiddle, registerLow}
+
+// validRegister returns true if req.register is empty or a known strategy.
+func validRegister(req etudeRequest) bool {
+	switch req.register {
+	case "", registerLow, registerMiddle, registerHigh, registerBalanced:
+		return true
+	}
+	return false
+}
+
+// patternRegisters returns the register to target for each of n patterns
+// placed with strategy register, or nil for the default random walk.
+func patternRegisters(register string, n int) (registers []string) {
+	switch register {
+	case "":
+		return nil
+	case registerBalanced:
+		for i := 0; i < n; i++ {
+			registers = append(registers, registerSweep[i*len(registerSweep)/n])
+		}
+	default:
+		for i := 0; i < n; i++ {
+			registers = append(registers, register)
+		}
+	}
+	return
+}
+
+// registerBand returns the lowest and highest pitches of the third of the
+// range midilo to midihi named by register.
+func registerBand(register string, midilo, midihi int) (lo, hi int) {
+	width := (midihi - midilo + 1) / 3
+	switch register {
+	case registerLow:
+		return midilo, midilo + width - 1
+	case registerHigh:
+		return midihi - width + 1, midihi
+	default:
+		return midilo + width, midihi - width
+	}
+}
+
+// registerPrior returns the pitch that constrain should place the first
+// note of t near so that t lies between lo and hi. It is prior itself when
+// that already works, which keeps the voice leading smooth within a
+// register, otherwise the nearest pitch that does. Patterns wider than the
+// band are centered on it.
+func registerPrior(t midiPattern, prior, lo, hi int) int {
+	tlo, thi := t[0], t[0]
+	for _, p := range t {
+		if p < tlo {
+			tlo = p
+		}
+		if p > thi {
+			thi = p
+		}
+	}
+	first, last := lo+t[0]-tlo, hi-(thi-t[0])
+	if first > last {
+		first = (first + last) / 2
+		last = first
+	}
+	switch {
+	case prior < first:
+		return first
+	case prior > last:
+		return last
+	}
+	return prior
+}
+
+// fitRegister shifts t by octaves so that all of its notes lie between lo
+// and hi, choosing the shift that keeps the first note nearest prior. No
+// octave fits a pattern whose span plus an octave is wider than the band,
+// and such patterns are left as placed.
+func fitRegister(t midiPattern, prior, lo, hi int) {
+	tlo, thi := t[0], t[0]
+	for _, p := range t {
+		if p < tlo {
+			tlo = p
+		}
+		if p > thi {
+			thi = p
+		}
+	}
+	best, bestDist := 0, -1
+	for shift := -120; shift <= 120; shift += 12 {
+		if tlo+shift < lo || thi+shift > hi {
+			continue
+		}
+		dist := t[0] + shift - prior
+		if dist < 0 {
+			dist = -dist
+		}
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = shift, dist
+		}
+	}
+	for i := range t {
+		t[i] += best
+	}
+}
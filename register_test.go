@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPatternRegisters(t *testing.T) {
+	if r := patternRegisters("", 12); r != nil {
+		t.Errorf("expected nil for the random walk, got %v", r)
+	}
+	r := patternRegisters(registerBalanced, 12)
+	counts := map[string]int{}
+	for _, v := range r {
+		counts[v]++
+	}
+	for _, v := range []string{registerLow, registerMiddle, registerHigh} {
+		if counts[v] != 4 {
+			t.Errorf("expected 4 %s patterns, got %d in %v", v, counts[v], r)
+		}
+	}
+	if r[0] != registerLow || r[11] != registerLow {
+		t.Errorf("expected a balanced etude to start and end low, got %v", r)
+	}
+	for _, v := range patternRegisters(registerHigh, 5) {
+		if v != registerHigh {
+			t.Errorf("expected only high patterns, got %s", v)
+		}
+	}
+}
+
+func TestRegisterBand(t *testing.T) {
+	tests := []struct {
+		register string
+		lo, hi   int
+	}{
+		{registerLow, 48, 59}, {registerMiddle, 60, 72}, {registerHigh, 73, 84},
+	}
+	for _, test := range tests {
+		lo, hi := registerBand(test.register, 48, 84)
+		if lo != test.lo || hi != test.hi {
+			t.Errorf("%s: expected %d-%d, got %d-%d", test.register, test.lo, test.hi, lo, hi)
+		}
+	}
+}
+
+func TestRegisterPrior(t *testing.T) {
+	tests := []struct {
+		ptn           midiPattern
+		prior, lo, hi int
+		exp           int
+	}{
+		{midiPattern{60, 64, 67}, 62, 60, 72, 62}, // already fits
+		{midiPattern{60, 64, 67}, 50, 60, 72, 60}, // too low
+		{midiPattern{60, 64, 67}, 80, 60, 72, 65}, // too high, leave room for the fifth
+		{midiPattern{67, 64, 60}, 60, 60, 72, 67}, // starts on its highest note
+		{midiPattern{60, 76, 81}, 40, 60, 72, 55}, // wider than the band, centered
+	}
+	for _, test := range tests {
+		if got := registerPrior(test.ptn, test.prior, test.lo, test.hi); got != test.exp {
+			t.Errorf("%v prior %d in %d-%d: expected %d, got %d", test.ptn, test.prior, test.lo, test.hi, test.exp, got)
+		}
+	}
+}
+
+func TestFitRegister(t *testing.T) {
+	tests := []struct {
+		ptn           midiPattern
+		prior, lo, hi int
+		exp           midiPattern
+	}{
+		{midiPattern{57, 61, 57}, 57, 60, 79, midiPattern{69, 73, 69}}, // up an octave
+		{midiPattern{57, 61, 57}, 66, 48, 84, midiPattern{69, 73, 69}}, // nearest prior that fits
+		{midiPattern{60, 64, 60}, 62, 60, 72, midiPattern{60, 64, 60}}, // already fits
+		{midiPattern{57, 61, 57}, 57, 48, 59, midiPattern{57, 61, 57}}, // no octave fits
+	}
+	for _, test := range tests {
+		ptn := append(midiPattern{}, test.ptn...)
+		fitRegister(ptn, test.prior, test.lo, test.hi)
+		if !reflect.DeepEqual(ptn, test.exp) {
+			t.Errorf("%v prior %d in %d-%d: expected %v, got %v", test.ptn, test.prior, test.lo, test.hi, test.exp, ptn)
+		}
+	}
+}
+
+func TestRegisterPlacement(t *testing.T) {
+	seedEtudeRandom(1)
+	for _, register := range []string{registerLow, registerMiddle, registerHigh, registerBalanced} {
+		req := etudeRequest{pattern: "interval", interval1: "major3", register: register}
+		// bands of 20 notes fit every transposition of the pattern
+		s := generateEqualIntervalSequence(36, 96, 120, 0, req)
+		mkMidi(&s, true)
+		os.Remove(s.filename)
+		registers := patternRegisters(register, len(s.seq))
+		for i, ptn := range s.seq {
+			lo, hi := registerBand(registers[i], 36, 96)
+			for _, p := range ptn {
+				if p < lo || p > hi {
+					t.Errorf("%s: %v is outside the %s register %d-%d", register, ptn, registers[i], lo, hi)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Michael-F-Ellis/infinite-etudes/internal/miditempo"
+)
+
+// tickSeconds returns the time in seconds from the start of a midi file
+// with the tempo map tempos to tick.
+func tickSeconds(tempos []miditempo.TempoEvent, tick uint32) (secs float64) {
+	for i, t := range tempos {
+		if t.Tick >= tick {
+			break
+		}
+		end := tick
+		if i+1 < len(tempos) && tempos[i+1].Tick < tick {
+			end = tempos[i+1].Tick
+		}
+		secs += float64(end-t.Tick) * float64(t.MicrosPerBeat) / ticksPerBeat / 1e6
+	}
+	return
+}
+
+// patternStartSeconds returns the time in seconds at which each pattern of
+// seq begins in midi, an etude made from seq and req, followed by the time
+// at which the last pattern ends.
+func patternStartSeconds(midi []byte, seq []midiPattern, req *etudeRequest) (starts []float64, err error) {
+	tempos, err := miditempo.GetTempoMap(midi)
+	if err != nil {
+		return
+	}
+	for _, tick := range patternBounds(seq, req) {
+		starts = append(starts, tickSeconds(tempos, tick))
+	}
+	return
+}
+
+// patternBounds returns the tick at which each pattern of seq begins in an
+// etude made from seq and req, followed by the tick at which the last
+// pattern ends.
+func patternBounds(seq []midiPattern, req *etudeRequest) []uint32 {
+	ticks := patternStartTicks(&etudeSequence{seq: seq, req: *req})
+	end := countInTicks(req)
+	if len(ticks) > 0 {
+		last := seq[len(seq)-1]
+		end = ticks[len(ticks)-1] + uint32((1+req.repeats)*cellBars(len(last))*ticksPerBar)
+	}
+	return append(ticks, end)
+}
+
+// section returns the midi content of patterns from up to, but not
+// including, to of e, cut from the etude as served so that the section
+// plays the same notes, accents, mutes and metronome gaps at the same
+// tempos. A section has the etude's count-in, and midi is e.midi or a copy
+// of it at another tempo, as from retempo.
+func (e servedEtude) section(midi []byte, from, to int) ([]byte, error) {
+	bounds := patternBounds(e.seq, &e.req)
+	return miditempo.Excerpt(midi, countInTicks(&e.req), bounds[from], bounds[to])
+}
+
+// sectionHndlr responds to /section/<seed>/<from>/<to> with patterns <from>
+// up to <to>, counting from 0, of the recently served etude generated from
+// <seed>. An optional tempo query parameter sets the starting tempo as for
+// retempoHndlr. It gives a 404 if the etude is no longer in memory. The web
+// page loops and skips patterns with the event list from eventsHndlr, so
+// this is for MIDI players outside it, e.g. to save a hard pattern and
+// practice it with realistic instrument sounds.
+func sectionHndlr(w http.ResponseWriter, r *http.Request) {
+	e, args, ok := pathEtudeArgs(w, r, 2)
+	if !ok {
+		return
+	}
+	from, err1 := strconv.Atoi(args[0])
+	to, err2 := strconv.Atoi(args[1])
+	if err1 != nil || err2 != nil || from < 0 || to <= from || to > len(e.seq) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	midi, ok := queryTempo(w, r, e)
+	if !ok {
+		return
+	}
+	midi, err := e.section(midi, from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("could not cut section of etude %d: %v", e.seed, err)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/midi")
+	w.Header().Set("X-Etude-Seed", strconv.FormatInt(e.seed, 10))
+	if _, err = w.Write(midi); err != nil {
+		log.Printf("could not write section of etude %d: %v", e.seed, err)
+	}
+}
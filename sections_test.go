@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Michael-F-Ellis/infinite-etudes/internal/miditempo"
+)
+
+func TestTickSeconds(t *testing.T) {
+	// 120 bpm for a bar, then 60 bpm
+	tempos := []miditempo.TempoEvent{{Tick: 0, MicrosPerBeat: 500000}, {Tick: ticksPerBar, MicrosPerBeat: 1000000}}
+	for _, test := range []struct {
+		tick uint32
+		exp  float64
+	}{{0, 0}, {ticksPerBeat, 0.5}, {ticksPerBar, 2}, {ticksPerBar + ticksPerBeat, 3}, {2 * ticksPerBar, 6}} {
+		if got := tickSeconds(tempos, test.tick); got != test.exp {
+			t.Errorf("tick %d: expected %v, got %v", test.tick, test.exp, got)
+		}
+	}
+}
+
+func TestPatternStartSeconds(t *testing.T) {
+	s := etudeSequence{
+		seq:   []midiPattern{{60, 64, 61}, {60, 62, 64, 65, 67}},
+		tempo: 120,
+		req:   etudeRequest{repeats: 1, metroSound: defaultMetronomeSound},
+	}
+	s.midi = midiBytes(&s)
+	starts, err := patternStartSeconds(s.midi, s.seq, &s.req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a one bar count-in, two bars for the first pattern, four for the second
+	if exp := []float64{2, 6, 14}; !reflect.DeepEqual(starts, exp) {
+		t.Errorf("expected %v, got %v", exp, starts)
+	}
+}
+
+func TestSection(t *testing.T) {
+	seedEtudeRandom(1)
+	s := etudeSequence{
+		seq:   []midiPattern{{60, 64, 61}, {60, 62, 64}, {62, 65, 69}, {61, 63, 60}},
+		tempo: 60,
+		req:   etudeRequest{repeats: 2, mute: randomMute, rampTo: 120, metronome: metronomeRandomGaps, metroSound: defaultMetronomeSound},
+	}
+	s.midi = midiBytes(&s)
+	e := servedEtude{seq: s.seq, req: s.req, midi: s.midi}
+	midi, err := e.section(e.midi, 1, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the section plays the served notes of patterns 1 and 2 after the
+	// count-in, as played in the etude
+	bounds := patternBounds(s.seq, &s.req)
+	lead := countInTicks(&s.req)
+	var exp, got []miditempo.Event
+	all, _ := miditempo.GetEvents(s.midi)
+	for _, ev := range all {
+		if ev.Data[0] == 0x90 && ev.Data[2] > 0 && ev.Tick >= bounds[1] && ev.Tick < bounds[3] {
+			exp = append(exp, miditempo.Event{Tick: ev.Tick - bounds[1] + lead, Data: ev.Data})
+		}
+	}
+	all, _ = miditempo.GetEvents(midi)
+	for _, ev := range all {
+		if ev.Data[0] == 0x90 && ev.Data[2] > 0 && ev.Tick >= lead {
+			got = append(got, ev)
+		}
+	}
+	if len(exp) == 0 || !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected notes %v, got %v", exp, got)
+	}
+	// and keeps the ramp
+	etudeTempos, _ := miditempo.GetTempoMap(s.midi)
+	sectionTempos, _ := miditempo.GetTempoMap(midi)
+	at := func(tempos []miditempo.TempoEvent, tick uint32) (us uint) {
+		for _, tm := range tempos {
+			if tm.Tick <= tick {
+				us = tm.MicrosPerBeat
+			}
+		}
+		return
+	}
+	for _, tick := range []uint32{bounds[1], bounds[2], bounds[3] - 1} {
+		if a, b := at(etudeTempos, tick), at(sectionTempos, tick-bounds[1]+lead); a != b {
+			t.Errorf("tick %d: expected %d µs per beat, got %d", tick, a, b)
+		}
+	}
+	if at(sectionTempos, 0) != at(etudeTempos, 0) {
+		t.Errorf("expected the count-in at the starting tempo")
+	}
+}
+
+func TestSectionRequest(t *testing.T) {
+	resp, err := http.Get("http://" + testhost + "/etude/c/interval/minor3/minor2/minor2/trumpet/on/120/1/0")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	seed := resp.Header.Get("X-Etude-Seed")
+	resp, err = http.Get("http://" + testhost + "/section/" + seed + "/3/5?tempo=60")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	midi, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	n, _ := strconv.ParseInt(seed, 10, 64)
+	e, ok := recalledEtude(n)
+	if !ok {
+		t.Fatalf("etude %s not remembered", seed)
+	}
+	starts, err := patternStartSeconds(midi, e.seq[3:5], &e.req)
+	if exp := []float64{4, 12, 20}; err != nil || !reflect.DeepEqual(starts, exp) {
+		t.Errorf("expected two patterns at 60 bpm starting at %v, got %v, %v", exp, starts, err)
+	}
+	for _, path := range []string{"/section/" + seed + "/5/3", "/section/" + seed + "/0/13", "/section/" + seed + "/0/1?tempo=5"} {
+		resp, err = http.Get("http://" + testhost + path)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %v, got %v", path, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
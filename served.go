@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Michael-F-Ellis/infinite-etudes/internal/miditempo"
+)
+
+// servedEtude is an etude kept in memory after it has been served so it can
+// be served again in another form, e.g. at a different tempo, after its file
+// has expired.
+type servedEtude struct {
+	seed   int64         // seed for rng, see seedEtudeRandom
+	req    etudeRequest  // the request the etude was made from
+	seq    []midiPattern // the patterns in the order played
+	midi   []byte        // content of the midi file
+	served time.Time     // when the etude was last served
+	cards  []string      // review card keys drilled by an adaptive etude
+}
+
+// servedSeconds is how long a served etude stays in memory after it was last
+// served.
+var servedSeconds = 3600
+
+// maxServedEtudes limits the number of etudes kept in memory.
+const maxServedEtudes = 1000
+
+var servedMutex sync.Mutex
+
+// servedEtudes maps seeds to recently served etudes. Access it only while
+// holding servedMutex.
+var servedEtudes = map[int64]*servedEtude{}
+
+// rememberEtude adds the etude in s, generated with seed, to servedEtudes,
+// removing expired etudes and the oldest etude if there's no more room.
+func rememberEtude(seed int64, s *etudeSequence) {
+	servedMutex.Lock()
+	defer servedMutex.Unlock()
+	now := time.Now()
+	var oldest *servedEtude
+	for k, e := range servedEtudes {
+		if now.Sub(e.served) > time.Duration(servedSeconds)*time.Second {
+			delete(servedEtudes, k)
+			continue
+		}
+		if oldest == nil || e.served.Before(oldest.served) {
+			oldest = e
+		}
+	}
+	if len(servedEtudes) >= maxServedEtudes && oldest != nil {
+		delete(servedEtudes, oldest.seed)
+	}
+	servedEtudes[seed] = &servedEtude{seed: seed, req: s.req, seq: s.seq, midi: s.midi, served: now, cards: s.cards}
+}
+
+// recalledEtude returns a copy of the served etude with the given seed. It
+// returns false if there is none.
+func recalledEtude(seed int64) (e servedEtude, ok bool) {
+	servedMutex.Lock()
+	defer servedMutex.Unlock()
+	p, ok := servedEtudes[seed]
+	if !ok {
+		return
+	}
+	p.served = time.Now()
+	e = *p
+	return
+}
+
+// pathEtude returns the served etude named by the seed in a request path
+// of the form /<handler>/<seed>. If there's no such etude, it responds with
+// a 400 for a malformed path or a 404 for an unknown seed and returns false.
+func pathEtude(w http.ResponseWriter, r *http.Request) (e servedEtude, ok bool) {
+	e, _, ok = pathEtudeArgs(w, r, 0)
+	return
+}
+
+// pathEtudeArgs is pathEtude for request paths with nargs more segments
+// after the seed, which it returns in args.
+func pathEtudeArgs(w http.ResponseWriter, r *http.Request, nargs int) (e servedEtude, args []string, ok bool) {
+	path := strings.Split(r.URL.Path, "/")
+	if len(path) != 3+nargs {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	seed, err := strconv.ParseInt(path[2], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if e, ok = recalledEtude(seed); !ok {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	return e, path[3:], ok
+}
+
+// retempo returns a copy of the midi content of e with its tempo events
+// scaled so that the etude starts at tempo beats per minute. Ramped tempos
+// keep their shape.
+func retempo(e servedEtude, tempo int) (midi []byte, err error) {
+	tempos, err := miditempo.GetTempoMap(e.midi)
+	if err != nil {
+		return
+	}
+	if len(tempos) == 1 {
+		return miditempo.SetTempoBytes(e.midi, uint(60000000/tempo))
+	}
+	scale := float64(tempos[0].MicrosPerBeat) * float64(tempo) / 60000000
+	for i := range tempos {
+		tempos[i].MicrosPerBeat = uint(float64(tempos[i].MicrosPerBeat)/scale + 0.5)
+	}
+	return miditempo.SetTempoMap(e.midi, tempos)
+}
+
+// queryTempo returns the midi content of e at the starting tempo in the
+// optional tempo query parameter of r, as from retempo, or as served if
+// there's none. It responds with a 400 for a bad tempo or a 500 if the
+// etude can't be retempoed and returns false.
+func queryTempo(w http.ResponseWriter, r *http.Request, e servedEtude) (midi []byte, ok bool) {
+	v := r.URL.Query().Get("tempo")
+	if v == "" {
+		return e.midi, true
+	}
+	tempo, err := strconv.Atoi(v)
+	if err != nil || tempo < 20 || tempo > 600 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if midi, err = retempo(e, tempo); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("could not retempo etude %d: %v", e.seed, err)
+		return
+	}
+	return midi, true
+}
+
+// retempoHndlr responds to /retempo/<seed>/<tempo> with the recently served
+// etude generated from <seed> re-stamped at <tempo> beats per minute so that
+// students can slow down (or speed up) the exact etude they just played. It
+// gives a 404 if the etude is no longer in memory.
+func retempoHndlr(w http.ResponseWriter, r *http.Request) {
+	e, args, ok := pathEtudeArgs(w, r, 1)
+	if !ok {
+		return
+	}
+	tempo, err := strconv.Atoi(args[0])
+	if err != nil || tempo < 20 || tempo > 600 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	midi, err := retempo(e, tempo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("could not retempo etude %d: %v", e.seed, err)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/midi")
+	w.Header().Set("X-Etude-Seed", strconv.FormatInt(e.seed, 10))
+	if _, err = w.Write(midi); err != nil {
+		log.Printf("could not write etude %d: %v", e.seed, err)
+	}
+	log.Printf("%s etude %d served at %d bpm", r.RemoteAddr, e.seed, tempo)
+}
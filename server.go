@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,13 +28,11 @@ func randString(chars []rune, n uint) (out string) {
 */
 // serveEtudes serves etude midi files from the current working directory.
 func serveEtudes(hostport string, midijsPath string, imgPath string) {
-	err := mkWebPages()
+	// The index and lesson pages play etudes with Web Audio, so the midijs
+	// files are only served for players that still want them.
+	err := validDirPath(midijsPath)
 	if err != nil {
-		log.Fatalf("could not write web pages: %v", err)
-	}
-	err = validDirPath(midijsPath)
-	if err != nil {
-		log.Fatalf("invalid midijs path: %v", err)
+		log.Printf("not serving midijs files: %v", err)
 	}
 	os.Setenv("MIDIJS", midijsPath)
 	defer os.Unsetenv("MIDIJS")
@@ -43,10 +44,42 @@ func serveEtudes(hostport string, midijsPath string, imgPath string) {
 	os.Setenv("IMG", imgPath)
 	defer os.Unsetenv("IMG")
 
+	if lessonsPath != "" {
+		lessons, err = loadLessons(lessonsPath)
+		if err != nil {
+			log.Fatalf("could not load lessons: %v", err)
+		}
+		log.Printf("loaded %d lessons from %s", len(lessons), lessonsPath)
+	}
+
+	if historyPath != "" {
+		history, err = openHistory(historyPath)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer history.Close()
+		log.Printf("recording practice history in %s", historyPath)
+	}
+	// The index page leaves out the history controls when history is off.
+	err = mkWebPages()
+	if err != nil {
+		log.Fatalf("could not write web pages: %v", err)
+	}
+
 	http.Handle("/", http.HandlerFunc(indexHndlr))
 	http.Handle("/etude/", http.HandlerFunc(etudeHndlr))
 	http.Handle("/img/", http.HandlerFunc(imgHndlr))
 	http.Handle("/midijs/", http.HandlerFunc(midijsHndlr))
+	http.Handle("/history/", http.HandlerFunc(historyHndlr))
+	http.Handle("/review/", http.HandlerFunc(reviewHndlr))
+	http.Handle("/cards/", http.HandlerFunc(cardsHndlr))
+	http.Handle("/lesson/", http.HandlerFunc(lessonHndlr))
+	http.Handle("/retempo/", http.HandlerFunc(retempoHndlr))
+	http.Handle("/coverage/", http.HandlerFunc(coverageHndlr))
+	http.Handle("/tab/", http.HandlerFunc(tabHndlr))
+	http.Handle("/answers/", http.HandlerFunc(answersHndlr))
+	http.Handle("/section/", http.HandlerFunc(sectionHndlr))
+	http.Handle("/events/", http.HandlerFunc(eventsHndlr))
 	log.Printf("midijs path is %s", os.Getenv("MIDIJS"))
 	var serveSecure bool
 	var certpath, certkeypath string
@@ -123,58 +156,381 @@ func midijsHndlr(w http.ResponseWriter, r *http.Request) {
 }
 
 type etudeRequest struct {
-	tonalCenter string
-	pattern     string
-	interval1   string
-	interval2   string
-	interval3   string
-	instrument  string
-	tempo       string // beats per minute
-	repeats     int    // number of repeats (0-3)
-	metronome   int    // On, DownbeatOnly, Off
-	silent      int    // true indicated the corresponding repeat should be silent
-
+	tonalCenter   string
+	pattern       string
+	interval1     string
+	interval2     string
+	interval3     string
+	instrument    string
+	tempo         string // beats per minute
+	repeats       int    // number of repeats (0-maxRepeats)
+	metronome     int    // On, DownbeatOnly, Off, Eighths, Triplets, Backbeat
+	metroSound    string // metronome sound name from metronomeSounds, "" for wood blocks
+	metroVolume   int    // metronome channel volume, 1-127, 0 for the player's default
+	silent        int    // true indicated the corresponding repeat should be silent
+	mute          string // P (play) or M (mute) for each bar of a pattern, or "random"; overrides silent
+	user          string // optional user name for practice history
+	rampTo        int    // final tempo of an accelerando, 0 for a steady tempo
+	rampEvery     int    // patterns per accelerando step, 0 to step every bar
+	drone         string // "root" or "fifth" for a drone on the tonal center, "" for none
+	droneSound    string // GM sound file name prefix for the drone, e.g. "church_organ"
+	droneVolume   int    // drone channel volume, 1-127
+	progression   string // chord progression for the changes pattern, e.g. "ii-V-I"
+	backingSound  string // GM sound file name prefix for the backing chords
+	backingVolume int    // backing channel volume, 1-127
+	callSound     string // GM sound file name prefix for the first bar of each pattern, "" for the instrument
+	dynamics      string // dynamic shape, e.g. "crescendo", "" for none
+	articulation  string // note articulation, e.g. "staccato", "" for the default
+	tuning        string // tuning system, e.g. "just", "" for equal temperament
+	tuningMode    string // "bend" or "mts", how notes are retuned
+	direction     string // "ascending", "descending" or a contour like "ud", "" for mixed
+	cell          string // interval names for the intervalcell pattern, e.g. "minor3-major2-perfect4-minor2"
+	stepPercent   int    // percentage of stepwise moves in the melody pattern
+	maxLeap       string // name of the largest leap in the melody pattern
+	setClass      string // Forte number or prime form for the setclass pattern, e.g. "3-11" or "0146"
+	row           string // twelve-tone row for the tonerow pattern, e.g. "0e37t2546918", "" for a random row
+	rowForms      string // row forms for the tonerow pattern, e.g. "P-RI"
+	register      string // "low", "middle", "high" or "balanced" placement, "" for a random walk
+	position      int    // fretboard position for guitars and basses, 0 for anywhere
+	stringSet     string // strings for guitars and basses, e.g. "123", "" for all
+	syllables     string // "movable", "fixed" or "degrees" lyrics for each note, "" for none
+	countIn       string // "2" for two bars or "ready-go", "" for one bar
+	markers       bool   // true for a marker and text event at the start of each pattern
 }
 
+// Drone defaults used when a request asks for a drone without specifying
+// the sound or volume.
+const (
+	defaultDroneSound  = "string_ensemble_1"
+	defaultDroneVolume = 80
+)
+
 const (
 	metronomeOn int = iota
 	metronomeDownbeatOnly
 	metronomeOff
+	metronomeEighths    // every beat plus eighth note subdivisions
+	metronomeTriplets   // every beat plus triplet subdivisions
+	metronomeBackbeat   // beats 2 and 4 only
+	metronomeGaps       // every beat with progressively longer silent gaps
+	metronomeRandomGaps // every beat with silent gaps at random
 )
 
+// metronomeInvalid marks an unsupported metronome setting.
+const metronomeInvalid = -1
+
+// metronomeModes are the names of the metronome settings indexed by value.
+var metronomeModes = []string{"on", "downbeat", "off", "eighths", "triplets", "backbeat", "gaps", "randomgaps"}
+
 // metronomeString returns a string representation of the metronome integer value.
 func metronomeString(req *etudeRequest) (s string) {
-	switch req.metronome {
-	case metronomeOn:
-		s = "on"
-	case metronomeDownbeatOnly:
-		s = "downbeat"
-	case metronomeOff:
-		s = "off"
+	if req.metronome < 0 || req.metronome >= len(metronomeModes) {
+		return "invalid"
+	}
+	return metronomeModes[req.metronome]
+}
+
+// metronomeSegment returns the metronome component of etude URLs and file
+// names, mode[-sound[-volume]], e.g. "eighths-claves-100". The sound and
+// volume are omitted when they're the defaults.
+func metronomeSegment(req *etudeRequest) (s string) {
+	s = metronomeString(req)
+	if req.metroSound == "" && req.metroVolume == 0 {
+		return
+	}
+	sound := req.metroSound
+	if sound == "" {
+		sound = defaultMetronomeSound
+	}
+	s += "-" + sound
+	if req.metroVolume != 0 {
+		s += "-" + strconv.Itoa(req.metroVolume)
+	}
+	return
+}
+
+// maxRepeats is the largest supported number of repeats.
+const maxRepeats = 16
+
+// randomMute is the mute segment that mutes randomly chosen repeats.
+const randomMute = "random"
+
+var mutePatternRegexp = regexp.MustCompile(`^P[PM]*$`)
+
+// muteSegment returns the muting component of etude URLs and file names:
+// the 3-bit silent mask as a decimal number or, if set, the mute pattern.
+func (r *etudeRequest) muteSegment() string {
+	if r.mute != "" {
+		return r.mute
+	}
+	return strconv.Itoa(r.silent)
+}
+
+// parseMute sets the muting fields of req from a muting segment, which is
+// either a silent mask, a mute pattern like "PPMMPM" with one letter for
+// each bar of a pattern, or "random". Unsupported values are left for
+// validEtudeRequest to reject.
+func parseMute(segment string, req *etudeRequest) {
+	if silent, err := strconv.Atoi(segment); err == nil {
+		req.silent = silent
+		return
+	}
+	req.mute = segment
+}
+
+// validMute returns true if the muting fields of req are supported.
+func validMute(req etudeRequest) (ok bool) {
+	switch req.mute {
+	case "":
+		ok = req.silent >= 0 && req.silent <= 7
+	case randomMute:
+		ok = true
 	default:
-		s = "invalid"
+		ok = mutePatternRegexp.MatchString(req.mute) && len(req.mute) == 1+req.repeats
 	}
 	return
 }
+
+// parseMetronome sets the metronome fields of req from a metronome
+// segment as described for metronomeSegment. Unsupported values are left
+// for validEtudeRequest to reject.
+func parseMetronome(segment string, req *etudeRequest) {
+	parts := strings.Split(segment, "-")
+	req.metronome = metronomeInvalid
+	for i, mode := range metronomeModes {
+		if parts[0] == mode {
+			req.metronome = i
+		}
+	}
+	if len(parts) > 3 {
+		req.metronome = metronomeInvalid
+		return
+	}
+	if len(parts) > 1 && parts[1] != defaultMetronomeSound {
+		req.metroSound = parts[1]
+	}
+	if len(parts) > 2 {
+		v, err := strconv.Atoi(parts[2])
+		if err != nil {
+			v = -1 // invalid
+		}
+		req.metroVolume = v
+	}
+}
 func (r *etudeRequest) midiFilename() (f string) {
 	var parts []string
 	repeats := fmt.Sprintf("%d", r.repeats)
-	silence := fmt.Sprintf("%d", r.silent)
+	silence := r.muteSegment()
 
 	switch r.pattern {
 	case "interval":
-		parts = []string{r.pattern, r.interval1, r.instrument, metronomeString(r), r.tempo, repeats, silence}
+		parts = []string{r.pattern, r.interval1, r.instrument, metronomeSegment(r), r.tempo, repeats, silence}
 	case "intervalpair":
-		parts = []string{r.pattern, r.interval1, r.interval2, r.instrument, metronomeString(r), r.tempo, repeats, silence}
+		parts = []string{r.pattern, r.interval1, r.interval2, r.instrument, metronomeSegment(r), r.tempo, repeats, silence}
 	case "intervaltriple":
-		parts = []string{r.pattern, r.interval1, r.interval2, r.interval3, r.instrument, metronomeString(r), r.tempo, repeats, silence}
+		parts = []string{r.pattern, r.interval1, r.interval2, r.interval3, r.instrument, metronomeSegment(r), r.tempo, repeats, silence}
+	case "intervalcell":
+		parts = []string{r.pattern, r.cell, r.instrument, metronomeSegment(r), r.tempo, repeats, silence}
+	case "setclass":
+		parts = []string{r.pattern, r.setClass, r.instrument, metronomeSegment(r), r.tempo, repeats, silence}
+	case "tonerow":
+		row := r.row
+		if row == "" {
+			row = "random"
+		}
+		parts = []string{r.pattern, row, r.rowForms, r.instrument, metronomeSegment(r), r.tempo, repeats, silence}
+	case "adaptive": // content depends on the user's review cards
+		parts = []string{r.pattern, r.user, r.instrument, metronomeSegment(r), r.tempo, repeats, silence}
 	default:
-		parts = []string{r.tonalCenter, r.pattern, r.instrument, metronomeString(r), r.tempo, repeats, silence}
+		parts = []string{r.tonalCenter, r.pattern, r.instrument, metronomeSegment(r), r.tempo, repeats, silence}
 	}
+	parts = append(parts, r.optionParts()...)
 	f = strings.Join(parts, "_") + ".mid"
 	return
 }
 
+// optionParts returns filename components for the optional settings that
+// differ from their defaults.
+func (r *etudeRequest) optionParts() (parts []string) {
+	if r.rampTo != 0 {
+		every := "bar"
+		if r.rampEvery > 0 {
+			every = strconv.Itoa(r.rampEvery)
+		}
+		parts = append(parts, fmt.Sprintf("ramp%d-%s", r.rampTo, every))
+	}
+	if r.drone != "" {
+		parts = append(parts, fmt.Sprintf("drone%s-%s-%d", r.drone, r.droneSound, r.droneVolume))
+	}
+	if r.callSound != "" {
+		parts = append(parts, "call-"+r.callSound)
+	}
+	for _, v := range []string{r.dynamics, r.articulation} {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	if r.direction != "" {
+		parts = append(parts, r.direction)
+	}
+	if r.register != "" {
+		parts = append(parts, "register-"+r.register)
+	}
+	if r.position != 0 {
+		parts = append(parts, fmt.Sprintf("position%d", r.position))
+	}
+	if r.stringSet != "" {
+		parts = append(parts, "strings"+r.stringSet)
+	}
+	if r.syllables != "" {
+		parts = append(parts, "syllables-"+r.syllables)
+	}
+	if r.countIn != "" {
+		parts = append(parts, "countin-"+r.countIn)
+	}
+	if r.markers {
+		parts = append(parts, "markers")
+	}
+	if r.tuning != "" {
+		parts = append(parts, fmt.Sprintf("tuning-%s-%s", r.tuning, r.tuningMode))
+	}
+	if r.pattern == "melody" {
+		parts = append(parts, fmt.Sprintf("steps%d-%s", r.stepPercent, r.maxLeap))
+	}
+	if r.pattern == "changes" {
+		parts = append(parts, r.progression, fmt.Sprintf("backing-%s-%d", r.backingSound, r.backingVolume))
+	}
+	return
+}
+
+// parseEtudeOptions sets optional fields of req from query parameters.
+// Supported parameters are
+//
+//	rampto     final tempo of an accelerando in beats per minute
+//	rampevery  number of patterns between tempo increases or "bar" (default)
+//	drone      "root" or "fifth" to sustain the tonal center (or root and fifth)
+//	dronesound GM sound for the drone, e.g. "church_organ"
+//	dronevol   drone volume, 1-127
+//	progression  chord progression for the changes pattern, e.g. "ii-V-I"
+//	backingsound GM sound for the backing chords
+//	backingvol   backing chords volume, 1-127
+//	call         GM sound for the first bar (the call) of each pattern
+//	dynamics     "crescendo", "accents" or "terraced"
+//	articulation "staccato", "tenuto" or "legato"
+//	tuning       "just", "pythagorean", "meantone" or "quartertone"
+//	tuningmode   "bend" (default) or "mts" (MIDI Tuning Standard)
+//	direction    "ascending", "descending", "mixed" (default) or a contour of
+//	             u (up) and d (down) steps, e.g. "ud", for the interval patterns
+//	cell         interval names for the intervalcell pattern separated by "-"
+//	steps        percentage of stepwise moves in the melody pattern, 0-100
+//	maxleap      name of the largest leap in the melody pattern, e.g. "major6"
+//	setclass     Forte number or prime form for the setclass pattern, e.g. "4-Z15"
+//	row          twelve-tone row for the tonerow pattern written with 0-9, t
+//	             and e, e.g. "0e37t2546918"; random if empty
+//	rowforms     row forms for the tonerow pattern, e.g. "P-I-R-RI" (default)
+//	register     "low", "middle" or "high" to keep to one third of the
+//	             instrument's range, or "balanced" to visit each equally
+//	position     fretboard position for guitars and basses, the fret of the
+//	             index finger; 1 includes the open strings
+//	strings      strings for guitars and basses numbered from 1 for the
+//	             highest, e.g. "123". A position and strings that can't
+//	             finger every pattern in range are a bad request.
+//	syllables    "movable" (do), "fixed" (do) or "degrees" to add a lyric
+//	             to each note
+//	countin      "1" (default) or "2" bars of clicks before the first pattern,
+//	             or "ready-go" for two half notes and a bar of quarters
+//	markers      "on" to name each pattern and its intervals in marker and
+//	             text events, and count off the count-in in text events
+//
+// It returns an error if a value can't be parsed. Range checks are left to
+// validEtudeRequest.
+func parseEtudeOptions(q url.Values, req *etudeRequest) (err error) {
+	if v := q.Get("rampto"); v != "" {
+		if req.rampTo, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("bad rampto value: %v", err)
+		}
+	}
+	if v := q.Get("rampevery"); v != "" && v != "bar" {
+		if req.rampEvery, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("bad rampevery value: %v", err)
+		}
+	}
+	if req.drone = q.Get("drone"); req.drone != "" {
+		req.droneSound = defaultDroneSound
+		req.droneVolume = defaultDroneVolume
+	}
+	if v := q.Get("dronesound"); v != "" {
+		req.droneSound = v
+	}
+	if v := q.Get("dronevol"); v != "" {
+		if req.droneVolume, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("bad dronevol value: %v", err)
+		}
+	}
+	if req.pattern == "changes" {
+		req.progression = defaultProgression
+		req.backingSound = defaultBackingSound
+		req.backingVolume = defaultBackingVolume
+	}
+	if v := q.Get("progression"); v != "" {
+		req.progression = v
+	}
+	if v := q.Get("backingsound"); v != "" {
+		req.backingSound = v
+	}
+	req.callSound = q.Get("call")
+	req.dynamics = q.Get("dynamics")
+	req.articulation = q.Get("articulation")
+	if req.tuning = q.Get("tuning"); req.tuning != "" {
+		req.tuningMode = tuningModeBend
+	}
+	if v := q.Get("tuningmode"); v != "" {
+		req.tuningMode = v
+	}
+	req.cell = q.Get("cell")
+	if req.pattern == "melody" {
+		req.stepPercent = defaultStepPercent
+		req.maxLeap = defaultMaxLeap
+	}
+	if v := q.Get("steps"); v != "" {
+		if req.stepPercent, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("bad steps value: %v", err)
+		}
+	}
+	if v := q.Get("maxleap"); v != "" {
+		req.maxLeap = v
+	}
+	req.setClass = q.Get("setclass")
+	req.row = q.Get("row")
+	if req.pattern == "tonerow" {
+		req.rowForms = defaultRowForms
+	}
+	if v := q.Get("rowforms"); v != "" {
+		req.rowForms = v
+	}
+	req.register = q.Get("register")
+	if v := q.Get("position"); v != "" {
+		if req.position, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("bad position value: %v", err)
+		}
+	}
+	req.stringSet = q.Get("strings")
+	req.syllables = q.Get("syllables")
+	if req.countIn = q.Get("countin"); req.countIn == "1" {
+		req.countIn = ""
+	}
+	req.markers = q.Get("markers") == "on"
+	if req.direction = q.Get("direction"); req.direction == directionMixed {
+		req.direction = ""
+	}
+	if v := q.Get("backingvol"); v != "" {
+		if req.backingVolume, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("bad backingvol value: %v", err)
+		}
+	}
+	return
+}
+
 // etudeHndlr returns a midi file that matches the get request or a 404 for
 // incorrectly specified etudes. The pattern is
 // /etude/<key>/<scale>/<instrument>/<advancing> where <key> is a pitchname like
@@ -206,16 +562,7 @@ func etudeHndlr(w http.ResponseWriter, r *http.Request) {
 	req.interval2 = path[5]
 	req.interval3 = path[6]
 	req.instrument = path[7]
-	switch path[8] {
-	case "on":
-		req.metronome = metronomeOn
-	case "downbeat":
-		req.metronome = metronomeDownbeatOnly
-	case "off":
-		req.metronome = metronomeOff
-	default:
-		req.metronome = 4 // invalid
-	}
+	parseMetronome(path[8], &req)
 	req.tempo = path[9]
 	repeats, err := strconv.Atoi(path[10])
 	if err != nil {
@@ -224,23 +571,104 @@ func etudeHndlr(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	req.repeats = repeats
-	req.silent, err = strconv.Atoi(path[11])
-	if err != nil {
+	parseMute(path[11], &req)
+	req.user = r.URL.Query().Get("user")
+	if err = parseEtudeOptions(r.URL.Query(), &req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
+		log.Printf("%v", err)
 		return
 	}
+	serveEtude(w, r, req)
+}
+
+// serveEtude validates req and responds with the requested etude, generating
+// it if needed, or with a 400 if req is invalid. If req names a user, the
+// etude is recorded in the user's practice history.
+func serveEtude(w http.ResponseWriter, r *http.Request, req etudeRequest) {
 	if !validEtudeRequest(req) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	filename := (&req).midiFilename()
 	log.Printf("%s requested", filename)
-	makeEtudesIfNeeded(filename, req)
+	etude := makeEtudesIfNeeded(filename, req)
+	// record before serving so the history is there once the client has
+	// the etude
+	if req.user != "" && history != nil {
+		rec := newPracticeRecord(&req, etude, filename, time.Now())
+		if err := history.record(req.user, rec); err != nil {
+			log.Printf("could not record history for %s: %v", req.user, err)
+		}
+	}
+	w.Header().Set("X-Etude-Seed", strconv.FormatInt(etude.seed, 10))
 	http.ServeFile(w, r, filename)
 	// log the request in format that's convenient for analysis
 	log.Printf("%s %s served\n", r.RemoteAddr, filename)
 }
 
+// reviewHndlr records a user's difficulty grade for an interval or interval
+// pair. The pattern is POST /review/<user>/<card>/<grade> where <card> is an
+// interval name or two interval names joined by '-' and <grade> is 0 (hard)
+// through 5 (easy). It responds with the card's updated schedule as JSON.
+func reviewHndlr(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.Split(r.URL.Path, "/")
+	if len(path) != 5 || !validUserName(path[2]) || !validCardKey(path[3]) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	grade, err := strconv.Atoi(path[4])
+	if err != nil || grade < 0 || grade > 5 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if history == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	card, err := history.reviewCard(path[2], path[3], grade, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		log.Printf("review request: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(card)
+	if err != nil {
+		log.Printf("could not encode review card: %v", err)
+	}
+}
+
+// historyHndlr returns a JSON summary of a user's practice history. The
+// pattern is /history/<user>. It gives a 404 response if history is disabled
+// or the user has no profile.
+func historyHndlr(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(r.URL.Path, "/")
+	if len(path) != 3 || !validUserName(path[2]) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if history == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	user := path[2]
+	recs, err := history.records(user)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		log.Printf("history request: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(summarize(user, recs, time.Now()))
+	if err != nil {
+		log.Printf("could not encode history for %s: %v", user, err)
+	}
+}
+
 // removeExpiredMidiFiles deletes midi files in the current working
 // directory that are older than expireSeconds
 func removeExpiredMidiFiles() {
@@ -258,6 +686,7 @@ func removeExpiredMidiFiles() {
 				// something's really wrong
 				log.Fatalf("error removing %s: %v", fname, err)
 			}
+			delete(generatedEtudes, fname)
 		}
 	}
 
@@ -265,11 +694,22 @@ func removeExpiredMidiFiles() {
 
 var etudeMutex sync.Mutex
 
+// generatedEtude records how an etude file was generated.
+type generatedEtude struct {
+	seed     int64 // seed for rng, see seedEtudeRandom
+	patterns int   // number of patterns in the etude
+}
+
+// generatedEtudes maps the names of unexpired etude files to
+// information about how they were generated. Access it only while
+// holding etudeMutex.
+var generatedEtudes = map[string]generatedEtude{}
+
 // makeEtudesIfNeeded generates a full set of etudes in the current
-// working directory if the requested file doesn't exist or is older
-// than the age limit set by serveEtudes in os.Environ. Otherwise
-// it does nothing.
-func makeEtudesIfNeeded(filename string, req etudeRequest) {
+// working directory if the requested file doesn't exist, is older
+// than the age limit set by serveEtudes in os.Environ or wasn't
+// generated by this server run. Otherwise it does nothing. It returns the generation info for the file.
+func makeEtudesIfNeeded(filename string, req etudeRequest) (etude generatedEtude) {
 	// use the mutex to ensure that multiple requests can't
 	// create or delete files while a request is in process
 	etudeMutex.Lock()
@@ -279,10 +719,13 @@ func makeEtudesIfNeeded(filename string, req etudeRequest) {
 
 	// See if file exists
 	_, err := os.Stat(filename)
-	if !os.IsNotExist(err) { // See https://gist.github.com/mattes/d13e273314c3b3ade33f to understand this slightly weird test
+	known, ok := generatedEtudes[filename]
+	if !os.IsNotExist(err) && ok { // See https://gist.github.com/mattes/d13e273314c3b3ade33f to understand this slightly weird test
 		// file exists, nothing to do
-		return
+		return known
 	}
+	// A file left from before a restart is regenerated, since its seed
+	// is unknown and history records need it to reproduce the etude.
 	// need to generate if we get to here
 	iInfo, _ := getSupportedInstrumentByName(req.instrument) // already validated. ignore err value
 	// fmt.Printf("%v %s\n", iInfo, filename)
@@ -290,7 +733,13 @@ func makeEtudesIfNeeded(filename string, req etudeRequest) {
 	midilo := iInfo.midilo
 	midihi := iInfo.midihi
 	tempo, _ := strconv.Atoi(req.tempo)
-	mkRequestedEtude(midilo, midihi, tempo, instrument, req)
+	etude.seed = time.Now().UnixNano()
+	seedEtudeRandom(etude.seed)
+	s := mkRequestedEtude(midilo, midihi, tempo, instrument, req)
+	etude.patterns = len(s.seq)
+	generatedEtudes[filename] = etude
+	rememberEtude(etude.seed, &s)
+	return
 }
 
 // validEtudeRequest returns true if the request is correctly formed
@@ -319,21 +768,100 @@ func validEtudeRequest(req etudeRequest) (ok bool) {
 			!validIntervalName(req.interval3) {
 			return
 		}
+	case "intervalcell":
+		if !validCell(req) {
+			return
+		}
+	case "adaptive":
+		if req.user == "" {
+			return
+		}
+	case "changes":
+		if !validKeyName(req.tonalCenter) || !validBacking(req) {
+			return
+		}
+	case "melody":
+		if !validKeyName(req.tonalCenter) || !validMelody(req) {
+			return
+		}
+	case "setclass":
+		if prime, err := parseSetClass(req.setClass); err != nil || len(prime) < 3 || len(prime) > maxCellNotes {
+			return
+		}
+	case "tonerow":
+		if !validToneRow(req) {
+			return
+		}
 
 	default:
 		if !validKeyName(req.tonalCenter) {
 			return
 		}
 	}
+	if req.user != "" && !validUserName(req.user) {
+		return
+	}
 	if !validInstrumentName(req.instrument) {
 		return
 	}
+	if !intervalsFitRange(req) {
+		return
+	}
 	if !validMetronomePattern(metronomeString(&req)) {
 		return
 	}
+	if _, found := metronomeSoundByName(req.metroSound); !found {
+		return
+	}
+	if req.metroVolume < 0 || req.metroVolume > 127 {
+		return
+	}
 	if !validTempo(req.tempo) {
 		return
 	}
+	if req.rampTo != 0 && (req.rampTo < 20 || req.rampTo > 600) {
+		return
+	}
+	if req.rampEvery < 0 {
+		return
+	}
+	if req.repeats < 0 || req.repeats > maxRepeats || !validMute(req) {
+		return
+	}
+	if req.drone != "" && !validDrone(req) {
+		return
+	}
+	if _, found := gmFileNamePrefixToNum[req.callSound]; req.callSound != "" && !found {
+		return
+	}
+	switch req.dynamics {
+	case "", dynamicsCrescendo, dynamicsAccents, dynamicsTerraced:
+	default:
+		return
+	}
+	switch req.articulation {
+	case "", articulationStaccato, articulationTenuto, articulationLegato:
+	default:
+		return
+	}
+	if req.tuning != "" && !validTuning(req) {
+		return
+	}
+	if !validDirection(req) {
+		return
+	}
+	if !validRegister(req) {
+		return
+	}
+	if !validFretting(req) {
+		return
+	}
+	if !validSyllables(req) {
+		return
+	}
+	if !validCountIn(req) {
+		return
+	}
 	ok = true
 	return
 }
@@ -375,8 +903,21 @@ var intervalInfo = []nameInfo{
 	{"minor7", "Minor 7", "Minor Seventh", 10},
 	{"major7", "Major 7", "Major Seventh", 11},
 	{"octave", "Octave", "Octave", 12},
+	{"minor9", "Minor 9", "Minor Ninth", 13},
+	{"major9", "Major 9", "Major Ninth", 14},
+	{"minor10", "Minor 10", "Minor Tenth", 15},
+	{"major10", "Major 10", "Major Tenth", 16},
+	{"perfect11", "Perfect 11", "Perfect Eleventh", 17},
+	{"augmented11", "Augmented 11", "Augmented Eleventh", 18},
+	{"perfect12", "Perfect 12", "Perfect Twelfth", 19},
+	{"minor13", "Minor 13", "Minor Thirteenth", 20},
+	{"major13", "Major 13", "Major Thirteenth", 21},
 }
 
+// maxIntervalSize is the size in half steps of the largest interval in
+// intervalInfo.
+const maxIntervalSize = 21
+
 // intervalSizeByName returns the size of name in half-steps
 func intervalSizeByName(name string) (sz int) {
 	for _, inf := range intervalInfo {
@@ -399,6 +940,53 @@ func validIntervalName(name string) (ok bool) {
 	return
 }
 
+// intervalsFitRange returns true if the pitches of the interval patterns
+// requested by req fit in the range of the instrument. Only compound
+// intervals can make them too wide. Adaptive etudes leave out cards that
+// don't fit as they choose patterns.
+func intervalsFitRange(req etudeRequest) bool {
+	iInfo, err := getSupportedInstrumentByName(req.instrument)
+	if err != nil {
+		return false
+	}
+	if req.pattern == "allintervals" {
+		return true // one simple interval per pattern
+	}
+	return intervalSpan(requestIntervals(&req)) <= iInfo.midihi-iInfo.midilo
+}
+
+// intervalSpan returns the widest span in semitones of a pattern made from
+// the named intervals, the sum of their sizes.
+func intervalSpan(names []string) (span int) {
+	for _, name := range names {
+		span += intervalSizeByName(name)
+	}
+	return
+}
+
+// cellIntervalNames returns the names of the intervals in req.cell.
+func cellIntervalNames(req *etudeRequest) []string {
+	if req.cell == "" {
+		return nil
+	}
+	return strings.Split(req.cell, "-")
+}
+
+// validCell returns true if req.cell names 2 to maxCellNotes-1 supported
+// intervals.
+func validCell(req etudeRequest) bool {
+	names := cellIntervalNames(&req)
+	if len(names) < 2 || len(names) > maxCellNotes-1 {
+		return false
+	}
+	for _, name := range names {
+		if !validIntervalName(name) {
+			return false
+		}
+	}
+	return true
+}
+
 // extractIntervalPair returns two interval sizes from an interval pair string
 // of the form "N-M" where N and M are interval sizes in half steps
 func extractIntervalPair(s string) (i1 int, i2 int, err error) {
@@ -412,7 +1000,7 @@ func extractIntervalPair(s string) (i1 int, i2 int, err error) {
 		err = fmt.Errorf("bad string for first interval: %v", err)
 		return
 	}
-	if i1 < 1 || i1 > 12 {
+	if i1 < 1 || i1 > maxIntervalSize {
 		err = fmt.Errorf("bad value for first interval: %d", i1)
 		return
 	}
@@ -421,7 +1009,7 @@ func extractIntervalPair(s string) (i1 int, i2 int, err error) {
 		err = fmt.Errorf("bad string for second interval: %v", err)
 		return
 	}
-	if i2 < 1 || i2 > 12 {
+	if i2 < 1 || i2 > maxIntervalSize {
 		err = fmt.Errorf("bad value for second interval: %d", i2)
 		return
 	}
@@ -444,6 +1032,12 @@ var patternInfo = []nameInfo{
 	{"allintervals", "Tonic Intervals", "Tonic Intervals", 0},
 	{"intervalpair", "Two Intervals", "Two Intervals", 0},
 	{"intervaltriple", "Three Intervals", "Three Intervals", 0},
+	{"intervalcell", "Interval Cell", "Interval Cell", 0},
+	{"adaptive", "Adaptive", "Adaptive", 0},
+	{"changes", "Chord Changes", "Chord Changes", 0},
+	{"melody", "Random Melody", "Random Melody", 0},
+	{"setclass", "Set Class", "Set Class", 0},
+	{"tonerow", "Tone Row", "Tone Row", 0},
 }
 
 // validPattern returns true if the scale name is in the ones we support.
@@ -468,12 +1062,44 @@ func validInstrumentName(name string) (ok bool) {
 }
 
 func validMetronomePattern(name string) (ok bool) {
-	switch name {
-	case "on", "downbeat", "off":
-		ok = true
+	for _, mode := range metronomeModes {
+		if name == mode {
+			ok = true
+		}
+	}
+	return
+}
+
+// validDrone returns true if the drone settings in req are supported. Drones
+// sustain the tonal center, so they're only offered with the Tonic Intervals
+// pattern.
+func validDrone(req etudeRequest) (ok bool) {
+	if req.pattern != "allintervals" {
+		return
+	}
+	if req.drone != "root" && req.drone != "fifth" {
+		return
+	}
+	if _, found := gmFileNamePrefixToNum[req.droneSound]; !found {
+		return
+	}
+	ok = req.droneVolume >= 1 && req.droneVolume <= 127
+	return
+}
+
+// validBacking returns true if the chord progression and backing settings in
+// req are supported.
+func validBacking(req etudeRequest) (ok bool) {
+	if _, err := progressionDegrees(req.progression); err != nil {
+		return
 	}
+	if _, found := gmFileNamePrefixToNum[req.backingSound]; !found {
+		return
+	}
+	ok = req.backingVolume >= 1 && req.backingVolume <= 127
 	return
 }
+
 func validTempo(ts string) (ok bool) {
 	_, err := strconv.Atoi(ts)
 	if err == nil {
@@ -2,13 +2,17 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/Michael-F-Ellis/infinite-etudes/internal/miditempo"
 )
 
 var testhost = "localhost:8080"
@@ -50,6 +54,30 @@ func TestGoodEtudeRequest(t *testing.T) {
 			url:      "http://" + testhost + "/etude/aflat/intervalpair/minor2/minor2/minor2/trumpet/on/120/1/0",
 			filename: "intervalpair_minor2_minor2_trumpet_on_120_1_0.mid",
 		},
+		{
+			url:      "http://" + testhost + "/etude/g/changes/minor2/minor2/minor2/trumpet/on/120/1/0?progression=ii-V-I",
+			filename: "g_changes_trumpet_on_120_1_0_ii-V-I_backing-acoustic_grand_piano-80.mid",
+		},
+		{
+			url:      "http://" + testhost + "/etude/c/interval/perfect4/minor2/minor2/trumpet/triplets-cowbell-100/120/1/0",
+			filename: "interval_perfect4_trumpet_triplets-cowbell-100_120_1_0.mid",
+		},
+		{
+			url:      "http://" + testhost + "/etude/c/interval/perfect5/minor2/minor2/trumpet/on/120/5/PPMMPM",
+			filename: "interval_perfect5_trumpet_on_120_5_PPMMPM.mid",
+		},
+		{
+			url:      "http://" + testhost + "/etude/c/intervalcell/minor2/minor2/minor2/trumpet/on/120/1/0?cell=minor3-major2-perfect4-minor2-major2&direction=descending",
+			filename: "intervalcell_minor3-major2-perfect4-minor2-major2_trumpet_on_120_1_0_descending.mid",
+		},
+		{
+			url:      "http://" + testhost + "/etude/c/setclass/minor2/minor2/minor2/trumpet/on/120/1/0?setclass=4-Z15",
+			filename: "setclass_4-Z15_trumpet_on_120_1_0.mid",
+		},
+		{
+			url:      "http://" + testhost + "/etude/c/tonerow/minor2/minor2/minor2/trumpet/on/120/0/0?row=0e37t2546918&rowforms=P-RI",
+			filename: "tonerow_0e37t2546918_P-RI_trumpet_on_120_0_0.mid",
+		},
 	}
 	for _, tcase := range testTable {
 		resp, err := http.Get(tcase.url)
@@ -104,6 +132,25 @@ func TestVocalEtudeRequest(t *testing.T) {
 func TestValidEtudeRequest(t *testing.T) {
 	badRequests := []etudeRequest{
 		{tonalCenter: "hsharp", pattern: "pentatonic", instrument: "trumpet", tempo: "120"},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", drone: "root", droneSound: "church_organ", droneVolume: 80},
+		{tonalCenter: "c", pattern: "allintervals", instrument: "trumpet", tempo: "120", drone: "root", droneSound: "kazoo", droneVolume: 80},
+		{tonalCenter: "c", pattern: "allintervals", instrument: "trumpet", tempo: "120", drone: "fifth", droneSound: "church_organ", droneVolume: 128},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", repeats: 3, silent: 8},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", repeats: 17},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", repeats: 3, mute: "PPM"},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", repeats: 2, mute: "MPP"},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", repeats: 2, mute: "PXP"},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", callSound: "kazoo"},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", direction: "uu"},
+		{pattern: "intervaltriple", interval1: "minor3", interval2: "major3", interval3: "minor3", instrument: "trumpet", tempo: "120", direction: "ud"},
+		{tonalCenter: "c", pattern: "allintervals", instrument: "trumpet", tempo: "120", direction: directionDescending},
+		{pattern: "intervalpair", interval1: "major13", interval2: "major10", instrument: "trumpet", tempo: "120"},
+		{pattern: "intervalcell", cell: "minor3", instrument: "trumpet", tempo: "120"},
+		{pattern: "intervalcell", cell: "minor3-major3-minor3-major3-minor3-major3-minor3-major3", instrument: "acoustic_grand_piano", tempo: "120"},
+		{pattern: "intervalcell", cell: "minor3-kazoo3", instrument: "trumpet", tempo: "120"},
+		{tonalCenter: "c", pattern: "melody", instrument: "trumpet", tempo: "120", stepPercent: 101, maxLeap: "perfect5"},
+		{tonalCenter: "c", pattern: "melody", instrument: "trumpet", tempo: "120", stepPercent: 70, maxLeap: "major9"},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", register: "altissimo"},
 	}
 	for _, req := range badRequests {
 		ok := validEtudeRequest(req)
@@ -114,6 +161,17 @@ func TestValidEtudeRequest(t *testing.T) {
 	goodRequests := []etudeRequest{
 		{tonalCenter: "", pattern: "intervalpair", interval1: "minor3", interval2: "major3", instrument: "trumpet", metronome: metronomeDownbeatOnly, tempo: "120"},
 		{tonalCenter: "", pattern: "intervaltriple", interval1: "minor3", interval2: "major3", interval3: "minor3", instrument: "trumpet", metronome: metronomeOff, tempo: "120"},
+		{tonalCenter: "d", pattern: "allintervals", instrument: "viola", tempo: "120", drone: "fifth", droneSound: "church_organ", droneVolume: 80},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", repeats: 5, mute: "PPMMPM"},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", repeats: 8, mute: randomMute},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", repeats: 1, mute: "PM", callSound: "vibraphone"},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", direction: directionDescending},
+		{pattern: "intervaltriple", interval1: "minor3", interval2: "major3", interval3: "minor3", instrument: "trumpet", tempo: "120", direction: "dud"},
+		{pattern: "interval", interval1: "major13", instrument: "trumpet", tempo: "120"},
+		{pattern: "intervalpair", interval1: "minor9", interval2: "perfect12", instrument: "acoustic_grand_piano", tempo: "120"},
+		{tonalCenter: "g", pattern: "melody", instrument: "flute", tempo: "120", stepPercent: 90, maxLeap: "octave"},
+		{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120", register: registerHigh},
+		{tonalCenter: "c", pattern: "allintervals", instrument: "trumpet", tempo: "120", register: registerBalanced},
 	}
 	for _, req := range goodRequests {
 		ok := validEtudeRequest(req)
@@ -123,6 +181,32 @@ func TestValidEtudeRequest(t *testing.T) {
 	}
 
 }
+func TestMetronomeSegment(t *testing.T) {
+	for _, segment := range []string{"on", "downbeat", "eighths-claves", "triplets-woodblock-90", "backbeat-hihat-127", "gaps", "randomgaps-claves"} {
+		var req etudeRequest
+		parseMetronome(segment, &req)
+		if got := metronomeSegment(&req); got != segment {
+			t.Errorf("expected %s, got %s", segment, got)
+		}
+		req.pattern, req.interval1, req.instrument, req.tempo = "interval", "minor3", "trumpet", "120"
+		if !validEtudeRequest(req) {
+			t.Errorf("%s: request should have succeeded", segment)
+		}
+	}
+	var req etudeRequest
+	parseMetronome("on-woodblock", &req)
+	if got := metronomeSegment(&req); got != "on" {
+		t.Errorf("expected the default sound to be dropped, got %s", got)
+	}
+	req = etudeRequest{pattern: "interval", interval1: "minor3", instrument: "trumpet", tempo: "120"}
+	for _, segment := range []string{"sometimes", "on-kazoo", "on-claves-loud", "on-claves-128", "on-claves-90-x"} {
+		parseMetronome(segment, &req)
+		if validEtudeRequest(req) {
+			t.Errorf("%s: request should not have succeeded", segment)
+		}
+	}
+}
+
 func TestBadEtudeRequest(t *testing.T) {
 	badRequests := []string{
 		"/etude/c/pentatonic/minor2/minor2/minor2/trumpet/on/120",            // no repeat count
@@ -133,6 +217,7 @@ func TestBadEtudeRequest(t *testing.T) {
 		"/etude/c/pentatonic/minor2/minor2/toxic2/fromixhorn/on/120/3",       // bad instrument
 		"/etude/c/pentatonic/minor2/minor2/minor2/trumpet/jittery/120/3",     // bad rhythm
 		"/etude/c/pentatonic/minor2/minor2/minor2/trumpet/on/allaregretto/3", // bad tempo
+		"/etude/c/adaptive/minor2/minor2/minor2/trumpet/on/120/3/0",          // adaptive without user
 	}
 	for _, path := range badRequests {
 		url := "http://" + testhost + path
@@ -150,6 +235,170 @@ func TestBadEtudeRequest(t *testing.T) {
 	}
 }
 
+func TestHistoryRequest(t *testing.T) {
+	url := "http://" + testhost + "/etude/c/interval/minor3/minor2/minor2/trumpet/on/120/3/0?user=tester"
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Etude-Seed") == "" {
+		t.Errorf("expected an X-Etude-Seed header")
+	}
+	resp, err = http.Get("http://" + testhost + "/history/tester")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	var s historySummary
+	if err = json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		t.Fatalf("could not decode history: %v", err)
+	}
+	if s.Etudes != 1 || s.SecondsPerInterval["minor3"] == 0 {
+		t.Errorf("unexpected history %+v", s)
+	}
+	resp3, err := http.Post("http://"+testhost+"/review/tester/minor3/1", "", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp3.Body.Close()
+	if resp3.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %v, got %v", http.StatusOK, resp3.StatusCode)
+	}
+	url = "http://" + testhost + "/etude/c/adaptive/minor2/minor2/minor2/trumpet/on/120/3/0?user=tester"
+	resp4, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp4.Body.Close()
+	if resp4.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %v, got %v", http.StatusOK, resp4.StatusCode)
+	}
+	resp2, err := http.Get("http://" + testhost + "/history/nobody")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code %v, got %v", http.StatusNotFound, resp2.StatusCode)
+	}
+}
+
+func TestStaleEtudeFile(t *testing.T) {
+	// a file left from before a restart has no known seed
+	req := etudeRequest{pattern: "interval", interval1: "major6", instrument: "trumpet", tempo: "120", repeats: 1}
+	filename := req.midiFilename()
+	if err := ioutil.WriteFile(filename, []byte("stale"), 0644); err != nil {
+		t.Fatalf("could not write %s: %v", filename, err)
+	}
+	defer os.Remove(filename)
+	etude := makeEtudesIfNeeded(filename, req)
+	if etude.seed == 0 || etude.patterns != 12 {
+		t.Errorf("expected the etude to be regenerated with a seed, got %+v", etude)
+	}
+	if midi, _ := ioutil.ReadFile(filename); !bytes.HasPrefix(midi, []byte("MThd")) {
+		t.Errorf("expected a regenerated midi file")
+	}
+}
+
+func TestRetempoRequest(t *testing.T) {
+	url := "http://" + testhost + "/etude/c/interval/major6/minor2/minor2/flute/on/90/2/0"
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	orig, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("could not read etude: %v", err)
+	}
+	seed := resp.Header.Get("X-Etude-Seed")
+	// wait for the file to expire; the etude must still be available
+	time.Sleep(time.Duration(expireSeconds+1) * time.Second)
+	resp, err = http.Get("http://" + testhost + "/retempo/" + seed + "/60")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	midi, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	_, µs, err := miditempo.GetTempoBytes(midi)
+	if err != nil || µs != 1000000 {
+		t.Errorf("expected 1000000 µs per beat, got %d, %v", µs, err)
+	}
+	addr, _, _ := miditempo.GetTempoBytes(orig)
+	if !bytes.Equal(midi[:addr], orig[:addr]) || !bytes.Equal(midi[addr+3:], orig[addr+3:]) {
+		t.Errorf("expected the same etude at a new tempo")
+	}
+	for _, path := range []string{"/retempo/" + seed + "/10", "/retempo/x/60", "/retempo/" + seed} {
+		resp, err = http.Get("http://" + testhost + path)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %v, got %v", path, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+	resp, err = http.Get("http://" + testhost + "/retempo/1/60")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code %v, got %v", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestRetempoRamp(t *testing.T) {
+	s := etudeSequence{tempo: 100, seq: []midiPattern{{60, 62, 60}, {60, 64, 60}}}
+	s.req = etudeRequest{pattern: "interval", tempo: "100", repeats: 1, rampTo: 150, rampEvery: 1}
+	e := servedEtude{req: s.req, seq: s.seq, midi: midiBytes(&s)}
+	midi, err := retempo(e, 50)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tempos, err := miditempo.GetTempoMap(midi)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(tempos) != 2 || tempos[0].MicrosPerBeat != 1200000 || tempos[1].MicrosPerBeat != 800000 {
+		t.Errorf("expected ramp from 50 to 75 bpm, got %v", tempos)
+	}
+}
+
+func TestLessonRequest(t *testing.T) {
+	type testcase struct {
+		path   string
+		status int
+	}
+	for _, tc := range []testcase{
+		{"/lesson/", http.StatusOK},
+		{"/lesson/beginner", http.StatusOK},
+		{"/lesson/beginner/2", http.StatusOK},
+		{"/lesson/beginner/2?user=tester", http.StatusOK},
+		{"/lesson/beginner/2/etude/viola/80", http.StatusOK},
+		{"/lesson/beginner/2/etude/viola/200", http.StatusBadRequest}, // tempo out of range
+		{"/lesson/beginner/99", http.StatusNotFound},
+		{"/lesson/nosuchlesson", http.StatusNotFound},
+	} {
+		resp, err := http.Get("http://" + testhost + tc.path)
+		if err != nil {
+			t.Errorf("GET failed: %v", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected status code %v, got %v", tc.path, tc.status, resp.StatusCode)
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
 	// call flag.Parse() here if TestMain uses flags
 
@@ -173,7 +422,18 @@ func TestMain(m *testing.M) {
 		os.Exit(-1)
 	}
 	expireSeconds = 1
+	historyPath = "etudes.db"
+	lessonsPath = filepath.Join(wd, "lessons")
 	go serveEtudes(testhost, midijspath, imgpath) // max etude age = 1 second so we don't wait forever while testing.
+	// wait for the server to start listening before running the tests.
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", testhost)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
 	exitcode := m.Run()
 	err = os.Chdir(wd)
 	if err != nil {
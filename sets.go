@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// setClass is a pitch-class set class identified by its Forte number.
+type setClass struct {
+	forte string // Forte number, e.g. "3-11"
+	prime []int  // prime form, e.g. [0 3 7]
+}
+
+// setClasses are the trichords and tetrachords in Forte's order. Larger sets
+// can be requested by prime form.
+var setClasses = []setClass{
+	{"3-1", []int{0, 1, 2}}, {"3-2", []int{0, 1, 3}}, {"3-3", []int{0, 1, 4}},
+	{"3-4", []int{0, 1, 5}}, {"3-5", []int{0, 1, 6}}, {"3-6", []int{0, 2, 4}},
+	{"3-7", []int{0, 2, 5}}, {"3-8", []int{0, 2, 6}}, {"3-9", []int{0, 2, 7}},
+	{"3-10", []int{0, 3, 6}}, {"3-11", []int{0, 3, 7}}, {"3-12", []int{0, 4, 8}},
+	{"4-1", []int{0, 1, 2, 3}}, {"4-2", []int{0, 1, 2, 4}}, {"4-3", []int{0, 1, 3, 4}},
+	{"4-4", []int{0, 1, 2, 5}}, {"4-5", []int{0, 1, 2, 6}}, {"4-6", []int{0, 1, 2, 7}},
+	{"4-7", []int{0, 1, 4, 5}}, {"4-8", []int{0, 1, 5, 6}}, {"4-9", []int{0, 1, 6, 7}},
+	{"4-10", []int{0, 2, 3, 5}}, {"4-11", []int{0, 1, 3, 5}}, {"4-12", []int{0, 2, 3, 6}},
+	{"4-13", []int{0, 1, 3, 6}}, {"4-14", []int{0, 2, 3, 7}}, {"4-Z15", []int{0, 1, 4, 6}},
+	{"4-16", []int{0, 1, 5, 7}}, {"4-17", []int{0, 3, 4, 7}}, {"4-18", []int{0, 1, 4, 7}},
+	{"4-19", []int{0, 1, 4, 8}}, {"4-20", []int{0, 1, 5, 8}}, {"4-21", []int{0, 2, 4, 6}},
+	{"4-22", []int{0, 2, 4, 7}}, {"4-23", []int{0, 2, 5, 7}}, {"4-24", []int{0, 2, 4, 8}},
+	{"4-25", []int{0, 2, 6, 8}}, {"4-26", []int{0, 3, 5, 8}}, {"4-27", []int{0, 2, 5, 8}},
+	{"4-28", []int{0, 3, 6, 9}}, {"4-Z29", []int{0, 1, 3, 7}},
+}
+
+// pcDigits are the characters used for pitch classes 0 to 11 in prime forms
+// and rows, with t and e for 10 and 11.
+const pcDigits = "0123456789te"
+
+// pcString returns pcs written with pcDigits, e.g. "037".
+func pcString(pcs []int) string {
+	var b strings.Builder
+	for _, pc := range pcs {
+		b.WriteByte(pcDigits[pc])
+	}
+	return b.String()
+}
+
+// parsePCs returns the pitch classes in s, written with pcDigits. It
+// returns an error if s has other characters or repeats a pitch class.
+func parsePCs(s string) (pcs []int, err error) {
+	seen := map[int]bool{}
+	for _, c := range strings.ToLower(s) {
+		pc := strings.IndexRune(pcDigits, c)
+		if pc < 0 {
+			return nil, fmt.Errorf("%q is not a pitch class in %q", c, s)
+		}
+		if seen[pc] {
+			return nil, fmt.Errorf("pitch class %c appears more than once in %q", c, s)
+		}
+		seen[pc] = true
+		pcs = append(pcs, pc)
+	}
+	return
+}
+
+// normalForm returns the most compact ascending rotation of the pitch
+// classes in pcs, transposed to begin on 0. As in Rahn's method, ties go to
+// the rotation packed most tightly toward the first pitch class.
+func normalForm(pcs []int) []int {
+	sorted := append([]int{}, pcs...)
+	sort.Ints(sorted)
+	n := len(sorted)
+	var best []int
+	for r := 0; r < n; r++ {
+		rot := make([]int, n)
+		for i := range rot {
+			rot[i] = ((sorted[(r+i)%n]-sorted[r])%12 + 12) % 12
+		}
+		if best == nil || morePacked(rot, best) {
+			best = rot
+		}
+	}
+	return best
+}
+
+// morePacked returns true if a, spanning less or packed more tightly toward
+// its first element, is more compact than b. Both begin on 0.
+func morePacked(a, b []int) bool {
+	for i := len(a) - 1; i > 0; i-- {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// primeForm returns the prime form of pcs: the more compact of the normal
+// forms of pcs and of its inversion.
+func primeForm(pcs []int) []int {
+	inv := make([]int, len(pcs))
+	for i, pc := range pcs {
+		inv[i] = (12 - pc) % 12
+	}
+	p, q := normalForm(pcs), normalForm(inv)
+	if morePacked(q, p) {
+		return q
+	}
+	return p
+}
+
+// parseSetClass returns the prime form of name, which is either a Forte
+// number from setClasses, e.g. "4-Z15", or pitch classes written with
+// pcDigits, e.g. "0146".
+func parseSetClass(name string) (prime []int, err error) {
+	for _, sc := range setClasses {
+		if strings.EqualFold(sc.forte, name) {
+			return sc.prime, nil
+		}
+	}
+	pcs, err := parsePCs(name)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a Forte number nor a pitch class set", name)
+	}
+	return primeForm(pcs), nil
+}
+
+// setClassPatterns is the number of patterns in a Set Class etude: the
+// prime form and its inversion on each pitch of the chromatic scale.
+const setClassPatterns = 24
+
+// generateSetClassSequence returns an etudeSequence with the set class
+// req.setClass and its inversion transposed to each pitch in the chromatic
+// scale. Each pattern is in a different note order, chosen as for Three
+// Intervals etudes.
+func generateSetClassSequence(midilo int, midihi int, tempo int, instrument int, req etudeRequest) (sequence etudeSequence) {
+	prime, err := parseSetClass(req.setClass)
+	if err != nil {
+		panic(err) // already validated
+	}
+	n := len(prime)
+	// the inversion, packed from the bottom like the prime form
+	inversion := make([]int, n)
+	for i := range prime {
+		inversion[i] = prime[n-1] - prime[n-1-i]
+	}
+	sequence = etudeSequence{
+		midilo:     midilo,
+		midihi:     midihi,
+		tempo:      tempo,
+		instrument: instrument,
+		req:        req,
+	}
+	orders := noteOrders(n, setClassPatterns)
+	for i, idx := range orders {
+		form := prime
+		if i >= 12 {
+			form = inversion
+		}
+		ptn := make(midiPattern, n)
+		for j := range ptn {
+			ptn[j] = i%12 + form[idx[j]]
+		}
+		sequence.seq = append(sequence.seq, ptn)
+	}
+	return
+}
+
+// Twelve-tone row forms for etudeRequest.rowForms.
+const (
+	rowPrime      = "P"
+	rowInversion  = "I"
+	rowRetrograde = "R"
+	rowRetroInv   = "RI"
+)
+
+// defaultRowForms are the row forms used when a Tone Row request doesn't
+// specify them.
+const defaultRowForms = "P-I-R-RI"
+
+// toneRowPatterns is the number of row forms in a Tone Row etude.
+const toneRowPatterns = 12
+
+// parseRow returns the pitch classes of a twelve-tone row written with
+// pcDigits, e.g. "0e37t2546918".
+func parseRow(s string) (row []int, err error) {
+	if row, err = parsePCs(s); err != nil {
+		return
+	}
+	if len(row) != 12 {
+		err = fmt.Errorf("row %q has %d pitch classes, not 12", s, len(row))
+	}
+	return
+}
+
+// randomRow returns a random twelve-tone row.
+func randomRow() []int {
+	row := midiPattern(getChromaticScale())
+	shufflePatternPitches(&row)
+	return row
+}
+
+// rowForm returns the form of row, rowPrime, rowInversion, rowRetrograde or
+// rowRetroInv, transposed so that its P or I form starts on pitch class t.
+func rowForm(row []int, form string, t int) (pcs []int) {
+	for _, pc := range row {
+		d := pc - row[0]
+		if form == rowInversion || form == rowRetroInv {
+			d = -d
+		}
+		pcs = append(pcs, ((t+d)%12+12)%12)
+	}
+	if form == rowRetrograde || form == rowRetroInv {
+		for i, j := 0, len(pcs)-1; i < j; i, j = i+1, j-1 {
+			pcs[i], pcs[j] = pcs[j], pcs[i]
+		}
+	}
+	return
+}
+
+// rowFormNames returns the row forms in req.rowForms.
+func rowFormNames(req *etudeRequest) []string {
+	return strings.Split(req.rowForms, "-")
+}
+
+// validToneRow returns true if req has a valid row, or none for a random
+// one, and valid row forms.
+func validToneRow(req etudeRequest) bool {
+	if req.row != "" {
+		if _, err := parseRow(req.row); err != nil {
+			return false
+		}
+	}
+	for _, form := range rowFormNames(&req) {
+		switch form {
+		case rowPrime, rowInversion, rowRetrograde, rowRetroInv:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// generateToneRowSequence returns an etudeSequence of toneRowPatterns forms
+// of the row in req.row, or of a random row if there is none. The forms in
+// req.rowForms take turns, each at a different random transposition while
+// they last, and mkMidi shuffles their order. Each form keeps the order of
+// the row and lies within an octave, so it always fits the instrument's
+// range.
+func generateToneRowSequence(midilo int, midihi int, tempo int, instrument int, req etudeRequest) (sequence etudeSequence) {
+	row := randomRow()
+	if req.row != "" {
+		var err error
+		if row, err = parseRow(req.row); err != nil {
+			panic(err) // already validated
+		}
+	}
+	sequence = etudeSequence{
+		midilo:     midilo,
+		midihi:     midihi,
+		tempo:      tempo,
+		instrument: instrument,
+		req:        req,
+	}
+	forms := rowFormNames(&req)
+	transpositions := map[string]midiPattern{}
+	for i := 0; i < toneRowPatterns; i++ {
+		form := forms[i%len(forms)]
+		if len(transpositions[form]) == 0 {
+			t := midiPattern(getChromaticScale())
+			shufflePatternPitches(&t)
+			transpositions[form] = t
+		}
+		t := transpositions[form][0]
+		transpositions[form] = transpositions[form][1:]
+		sequence.seq = append(sequence.seq, midiPattern(rowForm(row, form, t)))
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPrimeForm(t *testing.T) {
+	for _, sc := range setClasses {
+		// transpose, invert and scramble the prime form
+		var pcs []int
+		for i := len(sc.prime) - 1; i >= 0; i-- {
+			pcs = append(pcs, (17-sc.prime[i])%12)
+		}
+		if got := primeForm(pcs); !reflect.DeepEqual(got, sc.prime) {
+			t.Errorf("%s: expected %v, got %v", sc.forte, sc.prime, got)
+		}
+	}
+	if got := normalForm([]int{11, 2, 7}); !reflect.DeepEqual(got, []int{0, 4, 7}) {
+		t.Errorf("expected the G major triad in normal form [0 4 7], got %v", got)
+	}
+	// Rahn's prime form of 5-20 is (01568), Forte's is (01378)
+	if got := primeForm([]int{0, 1, 3, 7, 8}); !reflect.DeepEqual(got, []int{0, 1, 5, 6, 8}) {
+		t.Errorf("expected [0 1 5 6 8], got %v", got)
+	}
+}
+
+func TestParseSetClass(t *testing.T) {
+	for name, exp := range map[string][]int{"4-z15": {0, 1, 4, 6}, "3-11": {0, 3, 7}, "47e": {0, 3, 7}, "0t": {0, 2}} {
+		got, err := parseSetClass(name)
+		if err != nil || !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: expected %v, got %v, %v", name, exp, got, err)
+		}
+	}
+	for _, name := range []string{"3-13", "00", "abc"} {
+		if _, err := parseSetClass(name); err == nil {
+			t.Errorf("expected an error for %q", name)
+		}
+	}
+}
+
+func TestGenerateSetClassSequence(t *testing.T) {
+	s := generateSetClassSequence(36, 84, 120, 0, etudeRequest{pattern: "setclass", setClass: "3-2"})
+	if len(s.seq) != setClassPatterns {
+		t.Fatalf("expected %d patterns, got %d", setClassPatterns, len(s.seq))
+	}
+	orders := map[string]int{}
+	for i, ptn := range s.seq {
+		sorted := append(midiPattern{}, ptn...)
+		sort.Ints(sorted)
+		exp := midiPattern{i % 12, i%12 + 1, i%12 + 3} // 013
+		if i >= 12 {
+			exp = midiPattern{i % 12, i%12 + 2, i%12 + 3} // 023
+		}
+		if !reflect.DeepEqual(sorted, exp) {
+			t.Errorf("pattern %d: expected %v, got %v", i, exp, ptn)
+		}
+		var order []int
+		for _, p := range ptn {
+			for j, q := range sorted {
+				if p == q {
+					order = append(order, j)
+				}
+			}
+		}
+		orders[pcString(order)]++
+	}
+	for order, n := range orders {
+		if n != 4 {
+			t.Errorf("expected order %s 4 times, got %d", order, n)
+		}
+	}
+}
+
+func TestRowForm(t *testing.T) {
+	row, err := parseRow("0e37t2546918")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		form string
+		t    int
+		exp  string
+	}{
+		{rowPrime, 0, "0e37t2546918"},
+		{rowPrime, 2, "215904768e3t"},
+		{rowInversion, 0, "01952t7863e4"},
+		{rowRetrograde, 0, "8196452t73e0"},
+		{rowRetroInv, 0, "4e3687t25910"},
+	}
+	for _, test := range tests {
+		if got := pcString(rowForm(row, test.form, test.t)); got != test.exp {
+			t.Errorf("%s%d: expected %s, got %s", test.form, test.t, test.exp, got)
+		}
+	}
+	for _, bad := range []string{"0e37t254691", "0e37t2546910", "0e37t254691x"} {
+		if _, err := parseRow(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestGenerateToneRowSequence(t *testing.T) {
+	req := etudeRequest{pattern: "tonerow", row: "0e37t2546918", rowForms: "P-RI"}
+	s := generateToneRowSequence(36, 84, 120, 0, req)
+	if len(s.seq) != toneRowPatterns {
+		t.Fatalf("expected %d patterns, got %d", toneRowPatterns, len(s.seq))
+	}
+	row, _ := parseRow(req.row)
+	seen := map[string]bool{}
+	for i, ptn := range s.seq {
+		form := []string{rowPrime, rowRetroInv}[i%2]
+		found := false
+		for n := 0; n < 12; n++ {
+			found = found || pcString(rowForm(row, form, n)) == pcString(ptn)
+		}
+		if !found || seen[pcString(ptn)] {
+			t.Errorf("pattern %d: %v is not a new %s form of the row", i, ptn, form)
+		}
+		seen[pcString(ptn)] = true
+	}
+	if !validToneRow(req) {
+		t.Error("expected a valid request")
+	}
+	req.rowForms = "P-X"
+	if validToneRow(req) {
+		t.Error("expected an invalid request")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Syllable systems for etudeRequest.syllables.
+const (
+	syllablesMovable = "movable" // movable do, do is the tonal center
+	syllablesFixed   = "fixed"   // fixed do, do is always C
+	syllablesDegrees = "degrees" // scale degree numbers from the tonal center
+)
+
+// chromaticSolfege are the movable and fixed do syllables for the twelve
+// pitch classes above do: di and fi for the raised tonic and fourth, me,
+// le and te for the lowered third, sixth and seventh, as most sight
+// singing courses teach them.
+var chromaticSolfege = []string{"do", "di", "re", "me", "mi", "fa", "fi", "sol", "le", "la", "te", "ti"}
+
+// chromaticDegrees are the scale degree numbers of the twelve pitch classes
+// above the tonic of a major key.
+var chromaticDegrees = []string{"1", "#1", "2", "b3", "3", "4", "#4", "5", "b6", "6", "b7", "7"}
+
+// pitchNames are the names used for pitch classes in answer sheets.
+var pitchNames = []string{"C", "Db", "D", "Eb", "E", "F", "Gb", "G", "Ab", "A", "Bb", "B"}
+
+// validSyllables returns true if req.syllables is empty or a known system.
+func validSyllables(req etudeRequest) bool {
+	switch req.syllables {
+	case "", syllablesMovable, syllablesFixed, syllablesDegrees:
+		return true
+	}
+	return false
+}
+
+// noteSyllable returns the syllable for pitch p of ptn in the system
+// system. Movable do and scale degrees are relative to the tonal center
+// or, for patterns without one, to the first note of the pattern.
+func noteSyllable(p int, ptn midiPattern, req *etudeRequest, system string) string {
+	pc := (p%12 + 12) % 12
+	switch system {
+	case syllablesFixed:
+		return chromaticSolfege[pc]
+	case syllablesDegrees:
+		return chromaticDegrees[(pc-tuningReference(ptn, req)+12)%12]
+	}
+	return chromaticSolfege[(pc-tuningReference(ptn, req)+12)%12]
+}
+
+// pitchName returns the name and octave of p, e.g. "Eb4".
+func pitchName(p int) string {
+	return fmt.Sprintf("%s%d", pitchNames[(p%12+12)%12], p/12-1)
+}
+
+// metaEvent returns a midi meta event of type kind holding text, e.g. a
+// lyric (metaLyric).
+func metaEvent(kind byte, text string) []byte {
+	return append(append([]byte{0xFF, kind}, varLen(uint32(len(text)))...), text...)
+}
+
+// answerSheet returns plain text listing the notes of each pattern in seq
+// with their syllables in the system requested by req, or in movable do
+// if req doesn't ask for one.
+func answerSheet(seq []midiPattern, req *etudeRequest) string {
+	system := req.syllables
+	if system == "" {
+		system = syllablesMovable
+	}
+	var sb strings.Builder
+	for i, ptn := range seq {
+		var notes, syllables strings.Builder
+		for _, p := range ptn {
+			fmt.Fprintf(&notes, " %-4s", pitchName(p))
+			fmt.Fprintf(&syllables, " %-4s", noteSyllable(p, ptn, req, system))
+		}
+		fmt.Fprintf(&sb, "Pattern %d\n %s\n %s\n\n", i+1, strings.TrimRight(notes.String(), " "), strings.TrimRight(syllables.String(), " "))
+	}
+	return sb.String()
+}
+
+// answersHndlr responds to /answers/<seed> with a plain text answer sheet
+// for the recently served etude generated from <seed>, or a 404 if the
+// etude is no longer in memory.
+func answersHndlr(w http.ResponseWriter, r *http.Request) {
+	e, ok := pathEtude(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := w.Write([]byte(answerSheet(e.seq, &e.req))); err != nil {
+		log.Printf("could not write answers for etude %d: %v", e.seed, err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNoteSyllable(t *testing.T) {
+	tests := []struct {
+		p      int
+		ptn    midiPattern
+		req    etudeRequest
+		system string
+		exp    string
+	}{
+		{62, midiPattern{62, 66, 69}, etudeRequest{pattern: "interval"}, syllablesMovable, "do"},
+		{66, midiPattern{62, 66, 69}, etudeRequest{pattern: "interval"}, syllablesMovable, "mi"},
+		{66, midiPattern{62, 66, 69}, etudeRequest{pattern: "interval"}, syllablesFixed, "fi"},
+		{66, midiPattern{62, 66, 69}, etudeRequest{pattern: "interval"}, syllablesDegrees, "3"},
+		{70, midiPattern{67, 70, 67}, etudeRequest{pattern: "allintervals", tonalCenter: "g"}, syllablesMovable, "me"},
+		{70, midiPattern{67, 70, 67}, etudeRequest{pattern: "allintervals", tonalCenter: "g"}, syllablesDegrees, "b3"},
+		{63, midiPattern{63, 65, 67}, etudeRequest{pattern: "melody", tonalCenter: "bflat"}, syllablesMovable, "fa"},
+	}
+	for _, test := range tests {
+		if got := noteSyllable(test.p, test.ptn, &test.req, test.system); got != test.exp {
+			t.Errorf("%d in %v (%s): expected %s, got %s", test.p, test.ptn, test.system, test.exp, got)
+		}
+	}
+}
+
+func TestMetaEvent(t *testing.T) {
+	exp := []byte{0xFF, 0x05, 0x03, 's', 'o', 'l'}
+	if got := metaEvent(metaLyric, "sol"); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected % x, got % x", exp, got)
+	}
+}
+
+func TestLyricEvents(t *testing.T) {
+	req := etudeRequest{pattern: "interval", repeats: 1, mute: "PM", syllables: syllablesMovable}
+	x := nBarsMusic(midiPattern{60, 64, 60}, &req).Bytes()
+	for _, lyric := range [][]byte{metaEvent(metaLyric, "do"), metaEvent(metaLyric, "mi")} {
+		if !bytes.Contains(x, lyric) {
+			t.Errorf("expected lyric % x in % x", lyric, x)
+		}
+	}
+	// only the unmuted first bar has lyrics
+	if n := bytes.Count(x, []byte{0xFF, metaLyric}); n != 3 {
+		t.Errorf("expected 3 lyrics, got %d", n)
+	}
+	if !bytes.HasPrefix(x, append(metaEvent(metaLyric, "do"), 0x00, 0x90, 60)) {
+		t.Errorf("expected the lyric just before its note, got % x", x)
+	}
+}
+
+func TestAnswerSheet(t *testing.T) {
+	req := etudeRequest{pattern: "allintervals", tonalCenter: "d", syllables: syllablesDegrees}
+	exp := "Pattern 1\n  D4   Ab4\n  1    #4\n\n"
+	if got := answerSheet([]midiPattern{{62, 68}}, &req); got != exp {
+		t.Errorf("expected %q, got %q", exp, got)
+	}
+}
+
+func TestAnswersRequest(t *testing.T) {
+	resp, err := http.Get("http://" + testhost + "/etude/c/melody/minor2/minor2/minor2/choir_aahs_alto/on/120/1/0?syllables=movable")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	resp, err = http.Get("http://" + testhost + "/answers/" + resp.Header.Get("X-Etude-Seed"))
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	// every melody ends on the tonic
+	if resp.StatusCode != http.StatusOK || strings.Count(string(body), " do\n") != melodyPatterns {
+		t.Errorf("expected %d melodies ending on do, got %d: %s", melodyPatterns, resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+)
+
+// Tuning systems for etudeRequest.tuning. An empty tuning is 12-tone equal
+// temperament.
+const (
+	tuningJust        = "just"
+	tuningPythagorean = "pythagorean"
+	tuningMeantone    = "meantone"
+	tuningQuarterTone = "quartertone"
+)
+
+// Ways of retuning notes for etudeRequest.tuningMode.
+const (
+	tuningModeBend = "bend" // a pitch bend before each note (default)
+	tuningModeMTS  = "mts"  // MIDI Tuning Standard scale/octave tuning
+)
+
+// tuningCents holds, for each tuning system, the deviation in cents from
+// equal temperament of each of the 12 semitones above the reference pitch.
+var tuningCents = map[string][12]float64{
+	// 5-limit just intonation: 1/1 16/15 9/8 6/5 5/4 4/3 45/32 3/2 8/5 5/3 16/9 15/8
+	tuningJust: {0, 11.73, 3.91, 15.64, -13.69, -1.96, -9.78, 1.96, 13.69, -15.64, -3.91, -11.73},
+	// Pythagorean: pure fifths from the reference, Db to F#
+	tuningPythagorean: {0, -9.78, 3.91, -5.87, 7.82, -1.96, 11.73, 1.96, -7.82, 5.87, -3.91, 9.78},
+	// Quarter comma meantone: fifths 3.42 cents narrow, Eb to G#
+	tuningMeantone: {0, -23.95, -6.84, 10.26, -13.69, 3.42, -20.53, -3.42, -27.37, -10.26, 6.84, -17.11},
+	// Quarter tone sharp: every pitch class but the reference a quarter tone
+	// sharp, so intervals from the reference, e.g. a minor third, become
+	// neutral while intervals between other notes stay equal tempered. It
+	// isn't 24-TET, which needs pitches between the semitones of a pattern.
+	tuningQuarterTone: {0, quarterTone, quarterTone, quarterTone, quarterTone, quarterTone,
+		quarterTone, quarterTone, quarterTone, quarterTone, quarterTone, quarterTone},
+}
+
+// quarterTone is half an equal tempered semitone in cents.
+const quarterTone = 50.0
+
+// validTuning returns true if the tuning settings in req are supported.
+func validTuning(req etudeRequest) (ok bool) {
+	if _, found := tuningCents[req.tuning]; !found {
+		return
+	}
+	switch req.tuningMode {
+	case tuningModeBend, tuningModeMTS:
+		ok = true
+	}
+	return
+}
+
+// tuningReference returns the pitch class that the tuning of ptn is
+// relative to: the tonal center for patterns in a key and the first note
+// of the pattern otherwise.
+func tuningReference(ptn midiPattern, req *etudeRequest) int {
+	switch req.pattern {
+	case "allintervals", "changes", "melody":
+		for i, name := range keyNames {
+			if name == req.tonalCenter {
+				return i
+			}
+		}
+	}
+	return ptn[0] % 12
+}
+
+// noteCents returns the deviation in cents from equal temperament of pitch
+// p in ptn for the tuning in req.
+func noteCents(p int, ptn midiPattern, req *etudeRequest) float64 {
+	ref := tuningReference(ptn, req)
+	return tuningCents[req.tuning][((p-ref)%12+12)%12]
+}
+
+// pitchBend returns a Pitch Bend event on channel (0-15) that raises or
+// lowers notes by cents, assuming the default bend range of 2 semitones.
+func pitchBend(channel byte, cents float64) []byte {
+	v := 8192 + int(math.Round(cents/200*8192))
+	if v < 0 {
+		v = 0
+	}
+	if v > 16383 {
+		v = 16383
+	}
+	return []byte{0xE0 | channel, byte(v & 0x7F), byte(v >> 7)}
+}
+
+// mtsScaleTuning returns a SysEx event, formatted for a midi track, with a
+// MIDI Tuning Standard real-time scale/octave tuning that retunes each pitch
+// class by cents[i], where i is its distance in semitones above the pitch
+// class ref. Unlike single note tuning changes, which apply to every
+// channel, it only retunes channels (0-15), so drones and backing chords
+// stay in equal temperament.
+func mtsScaleTuning(ref int, cents [12]float64, channels ...byte) []byte {
+	var mask uint32 // bit n for channel n
+	for _, ch := range channels {
+		mask |= 1 << ch
+	}
+	msg := []byte{0x7F, 0x7F, 0x08, 0x08, byte(mask >> 14), byte(mask >> 7 & 0x7F), byte(mask & 0x7F)}
+	for pc := 0; pc < 12; pc++ {
+		// 0x40 is equal temperament, each step a cent
+		v := 0x40 + int(math.Round(cents[((pc-ref)%12+12)%12]))
+		if v < 0 {
+			v = 0
+		}
+		if v > 0x7F {
+			v = 0x7F
+		}
+		msg = append(msg, byte(v))
+	}
+	msg = append(msg, 0xF7)
+	return append(append([]byte{0xF0}, varLen(uint32(len(msg)))...), msg...)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPitchBend(t *testing.T) {
+	tests := []struct {
+		channel byte
+		cents   float64
+		exp     []byte
+	}{
+		{0, 0, []byte{0xE0, 0x00, 0x40}},
+		{0, 50, []byte{0xE0, 0x00, 0x50}},  // 8192 + 2048
+		{3, -50, []byte{0xE3, 0x00, 0x30}}, // 8192 - 2048
+		{0, 400, []byte{0xE0, 0x7F, 0x7F}}, // beyond the bend range
+	}
+	for _, test := range tests {
+		if got := pitchBend(test.channel, test.cents); !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("%v cents on channel %d: expected % x, got % x", test.cents, test.channel, test.exp, got)
+		}
+	}
+}
+
+func TestNoteCents(t *testing.T) {
+	// just major third above the tonal center
+	req := etudeRequest{pattern: "allintervals", tonalCenter: "c", tuning: tuningJust}
+	if got := noteCents(64, midiPattern{62, 64}, &req); got != -13.69 {
+		t.Errorf("expected -13.69 cents for a just major third, got %v", got)
+	}
+	// melodies too are tuned from the tonal center, not their first note
+	req = etudeRequest{pattern: "melody", tonalCenter: "d", tuning: tuningJust}
+	if got := noteCents(66, midiPattern{66, 64, 62}, &req); got != -13.69 {
+		t.Errorf("expected -13.69 cents for the third of a melody in D, got %v", got)
+	}
+	// otherwise relative to the first note
+	req = etudeRequest{pattern: "interval", tuning: tuningJust}
+	if got := noteCents(65, midiPattern{62, 65}, &req); got != 15.64 {
+		t.Errorf("expected 15.64 cents for a just minor third, got %v", got)
+	}
+	// quarter tone sharp raises all but the reference pitch class, octaves
+	// included
+	req.tuning = tuningQuarterTone
+	ptn := midiPattern{62, 65, 74, 77, 62}
+	exp := []float64{0, 50, 0, 50, 0}
+	for i, p := range ptn {
+		if got := noteCents(p, ptn, &req); got != exp[i] {
+			t.Errorf("quartertone note %d: expected %v cents, got %v", i, exp[i], got)
+		}
+	}
+}
+
+func TestMTSScaleTuning(t *testing.T) {
+	// just intonation from D on channels 1 and 4
+	got := mtsScaleTuning(2, tuningCents[tuningJust], 0, 3)
+	exp := []byte{0xF0, 0x14, 0x7F, 0x7F, 0x08, 0x08, 0x00, 0x00, 0x09,
+		0x40 - 4, 0x40 - 12, 0x40, 0x40 + 12, 0x40 + 4, 0x40 + 16, 0x40 - 14, // C to F#
+		0x40 - 2, 0x40 - 10, 0x40 + 2, 0x40 + 14, 0x40 - 16, // G to B
+		0xF7}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected % x, got % x", exp, got)
+	}
+}
+
+func TestTunedBars(t *testing.T) {
+	ptn := midiPattern{60, 64, 67}
+	req := etudeRequest{tuning: tuningJust, tuningMode: tuningModeBend}
+	x := nBarsMusic(ptn, &req).Bytes()
+	// each note on is preceded by a pitch bend
+	if len(x) != 40 {
+		t.Fatalf("expected 40 bytes, got %d: % x", len(x), x)
+	}
+	if !reflect.DeepEqual(x[:8], []byte{0xE0, 0x00, 0x40, 0x00, 0x90, 60, 0x65, 0x87}) {
+		t.Errorf("expected an unbent tonic, got % x", x[:8])
+	}
+	if x[13] != 0xE0 || x[17] != 0x90 || x[18] != 64 {
+		t.Errorf("expected a pitch bend before the third, got % x", x[13:20])
+	}
+	req.tuningMode = tuningModeMTS
+	x = nBarsMusic(ptn, &req).Bytes()
+	if x[0] != 0xF0 || x[1] != 0x14 || x[8] != 0x09 {
+		t.Errorf("expected a tuning change before the notes, got % x", x)
+	}
+}
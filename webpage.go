@@ -45,10 +45,8 @@ func mkIndex() (err error) {
 		Meta(`name="keywords", content="music,notation,midi,tbon"`),
 		Link(`rel="stylesheet" href="https://www.w3schools.com/w3css/4/w3.css"`),
 		indexCSS(),
-		indexJS(), // js for this page
-		// js midi libraries
-		Script("src=/midijs/libtimidity.js charset=UTF-8"),
-		Script("src=/midijs/midi.js charset=UTF-8"),
+		indexJS(),  // js for this page
+		playerJS(), // the Web Audio player
 	)
 
 	// <html>
@@ -71,6 +69,9 @@ func indexBody() (body *HtmlTree) {
 	// Scale pattern
 	var scales []interface{}
 	for _, ptn := range patternInfo { // scaleInfo is defined in server.go
+		if ptn.fileName == "adaptive" && history == nil {
+			continue // adaptive etudes are drawn from the practice history
+		}
 		value := fmt.Sprintf(`value="%s"`, ptn.fileName)
 		scales = append(scales, Option(value, ptn.uiName))
 	}
@@ -94,22 +95,47 @@ func indexBody() (body *HtmlTree) {
 	interval1Select := Div(`class="Column" id="interval1-div"`, Label(``, "Interval 1", Select("id=interval1-select", intervals...)))
 	interval2Select := Div(`class="Column" id="interval2-div"`, Label(``, "Interval 2", Select("id=interval2-select", intervals...)))
 	interval3Select := Div(`class="Column" id="interval3-div"`, Label(``, "Interval 3", Select("id=interval3-select", intervals...)))
+	cellInput := Div(`class="Column" id="cell-div"`, Label(``, "Cell Intervals", Input(`type="text" id="cell-input" size="16" maxlength="24" placeholder="3 2 5 1"`)))
 	// Instrument sound
 	var sounds []interface{}
 	for _, iinfo := range supportedInstruments {
 		name := iinfo.displayName
 		value := fmt.Sprintf(`value="%s"`, iinfo.name)
+		if iinfo.fretboard != nil {
+			value += fmt.Sprintf(` data-strings="%d"`, len(iinfo.fretboard.open))
+		}
 		sounds = append(sounds, Option(value, name))
 	}
-	soundSelect := Div(`class="Column" id="sound-div"`, Label(``, "Instrument", Select("id=sound-select", sounds...)))
+	soundSelect := Div(`class="Column" id="sound-div"`, Label(``, "Instrument", Select(`id=sound-select onchange="manageInputs()"`, sounds...)))
+	calls := []interface{}{Option(`value=""`, "same as instrument")}
+	for _, name := range []string{"Acoustic Grand Piano", "Electric Piano 1", "Vibraphone", "Acoustic Guitar (nylon)", "Flute", "Choir Aahs"} {
+		calls = append(calls, Option(fmt.Sprintf(`value="%s"`, gmSoundFileNamePrefix(name)), name))
+	}
+	callSelect := Div(`class="Column" id="call-div"`, Label(``, "Call Sound", Select("id=call-select", calls...)))
 
 	// Metronome
 	var metros []interface{}
-	for _, ptn := range []string{"on", "downbeat", "off"} {
-		attrs := fmt.Sprintf(`value="%s"`, ptn)
-		metros = append(metros, Option(attrs, ptn))
+	for _, ptn := range []struct{ value, name string }{
+		{"on", "on"}, {"downbeat", "downbeat"}, {"off", "off"},
+		{"eighths", "eighths"}, {"triplets", "triplets"}, {"backbeat", "2 and 4"},
+		{"gaps", "growing gaps"}, {"randomgaps", "random gaps"},
+	} {
+		attrs := fmt.Sprintf(`value="%s"`, ptn.value)
+		metros = append(metros, Option(attrs, ptn.name))
 	}
 	metroSelect := Div(`class="Column" id="metro-div"`, Label(``, "Metronome", Select("id=metro-select", metros...))) // Metronome control
+	var clicks []interface{}
+	for _, sound := range metronomeSounds {
+		attrs := fmt.Sprintf(`value="%s"`, sound.name)
+		clicks = append(clicks, Option(attrs, sound.uiName))
+	}
+	clickSelect := Div(`class="Column" id="click-div"`, Label(``, "Click Sound", Select("id=click-select", clicks...)))
+	var clickVolumes []interface{}
+	for _, v := range []struct{ value, name string }{{"", "normal"}, {"50", "soft"}, {"127", "loud"}} {
+		attrs := fmt.Sprintf(`value="%s"`, v.value)
+		clickVolumes = append(clickVolumes, Option(attrs, v.name))
+	}
+	clickVolumeSelect := Div(`class="Column" id="clickvol-div"`, Label(``, "Click Volume", Select("id=clickvol-select", clickVolumes...)))
 
 	var tempos []interface{}
 	var tempoValues []int
@@ -126,11 +152,33 @@ func indexBody() (body *HtmlTree) {
 	}
 	tempoSelect := Div(`class="Column" id="tempo-div"`, Label(``, "Tempo", Select("id=tempo-select", tempos...)))
 
+	// Accelerando
+	rampTempos := []interface{}{Option(`value="off"`, "off")}
+	for _, bpm := range tempoValues {
+		rampTempos = append(rampTempos, Option(fmt.Sprintf(`value="%d"`, bpm), fmt.Sprintf("%d", bpm)))
+	}
+	rampSelect := Div(`class="Column" id="ramp-div"`, Label(``, "Speed Up To", Select("id=ramp-select", rampTempos...)))
+	var rampEvery []interface{}
+	for _, every := range []string{"bar", "1", "2", "4", "6"} {
+		name := every + " patterns"
+		switch every {
+		case "bar":
+			name = "bar"
+		case "1":
+			name = "pattern"
+		}
+		rampEvery = append(rampEvery, Option(fmt.Sprintf(`value="%s"`, every), name))
+	}
+	rampEverySelect := Div(`class="Column" id="rampevery-div"`, Label(``, "Every", Select("id=rampevery-select", rampEvery...)))
+
 	// Repeats
 	var repeats []interface{}
-	for _, reps := range []string{"3", "2", "1", "0"} {
-		attrs := fmt.Sprintf(`value="%s"`, reps)
-		repeats = append(repeats, Option(attrs, reps))
+	for reps := 0; reps <= 8; reps++ {
+		attrs := fmt.Sprintf(`value="%d"`, reps)
+		if reps == 3 {
+			attrs += " selected" // use 3 as the default value
+		}
+		repeats = append(repeats, Option(attrs, fmt.Sprintf("%d", reps)))
 	}
 	repeatSelect := Div(`class="Column" id="repeat-div"`, Label(``, "Repeats", Select("id=repeat-select", repeats...)))
 
@@ -140,19 +188,192 @@ func indexBody() (body *HtmlTree) {
 		attrs := fmt.Sprintf(`value="%d"`, ptn.value)
 		silences = append(silences, Option(attrs, ptn.html))
 	}
-	silenceSelect := Div(`class="Column" id="silence-div"`, Label(``, "Muting", Select("id=silence-select", silences...)))
+	silences = append(silences, Option(fmt.Sprintf(`value="%s"`, randomMute), "random"), Option(`value="custom"`, "custom"))
+	silenceSelect := Div(`class="Column" id="silence-div"`, Label(``, "Muting", Select(`id=silence-select onchange="manageInputs()"`, silences...)))
+	muteInput := Div(`class="Column" id="mute-div"`, Label(``, "Mute Pattern", Input(`type="text" id="mute-input" size="12" maxlength="17" placeholder="PPMP"`)))
+
+	// Dynamics and articulation
+	var dynamics []interface{}
+	for _, d := range []struct{ value, name string }{{"", "even"}, {"crescendo", "crescendo"}, {"accents", "random accents"}, {"terraced", "terraced"}} {
+		dynamics = append(dynamics, Option(fmt.Sprintf(`value="%s"`, d.value), d.name))
+	}
+	dynamicsSelect := Div(`class="Column"`, Label(``, "Dynamics", Select("id=dynamics-select", dynamics...)))
+	var articulations []interface{}
+	for _, a := range []struct{ value, name string }{{"", "normal"}, {"staccato", "staccato"}, {"tenuto", "tenuto"}, {"legato", "legato"}} {
+		articulations = append(articulations, Option(fmt.Sprintf(`value="%s"`, a.value), a.name))
+	}
+	articulationSelect := Div(`class="Column"`, Label(``, "Articulation", Select("id=articulation-select", articulations...)))
+
+	// Melodic direction for the interval patterns
+	var directions []interface{}
+	for _, d := range []string{directionMixed, directionAscending, directionDescending, "contour"} {
+		directions = append(directions, Option(fmt.Sprintf(`value="%s"`, d), d))
+	}
+	directionSelect := Div(`class="Column"`, Label(``, "Direction", Select(`id=direction-select onchange="manageInputs()"`, directions...)))
+	contourInput := Div(`class="Column" id="contour-div"`, Label(``, "Contour", Input(`type="text" id="contour-input" size="6" maxlength="3" placeholder="ud"`)))
+
+	// Tuning
+	var tunings []interface{}
+	for _, tn := range []struct{ value, name string }{
+		{"", "equal temperament"}, {tuningJust, "just intonation"}, {tuningPythagorean, "Pythagorean"},
+		{tuningMeantone, "quarter-comma meantone"}, {tuningQuarterTone, "quarter tone sharp"},
+	} {
+		tunings = append(tunings, Option(fmt.Sprintf(`value="%s"`, tn.value), tn.name))
+	}
+	tuningSelect := Div(`class="Column"`, Label(``, "Tuning", Select("id=tuning-select", tunings...)))
+	var tuningModes []interface{}
+	for _, m := range []struct{ value, name string }{{tuningModeBend, "pitch bend"}, {tuningModeMTS, "MIDI Tuning Standard"}} {
+		tuningModes = append(tuningModes, Option(fmt.Sprintf(`value="%s"`, m.value), m.name))
+	}
+	tuningModeSelect := Div(`class="Column"`, Label(``, "Retune With", Select("id=tuningmode-select", tuningModes...)))
+
+	// Fretboard position and strings for guitars and basses
+	positions := []interface{}{Option(`value="0"`, "anywhere")}
+	for n := 1; n <= guitar12.frets-positionFrets+2; n++ { // every guitar and bass has these
+		positions = append(positions, Option(fmt.Sprintf(`value="%d"`, n), fmt.Sprint(n)))
+	}
+	positionSelect := Div(`class="Column"`, Label(``, "Position", Select("id=position-select", positions...)))
+	stringsInput := Div(`class="Column"`, Label(``, "Strings", Input(`type="text" id="strings-input" size="6" maxlength="6" placeholder="all"`)))
+
+	// Syllables for singers
+	var syllables []interface{}
+	for _, sy := range []struct{ value, name string }{{"", "none"}, {syllablesMovable, "movable do"}, {syllablesFixed, "fixed do"}, {syllablesDegrees, "scale degrees"}} {
+		syllables = append(syllables, Option(fmt.Sprintf(`value="%s"`, sy.value), sy.name))
+	}
+	syllablesSelect := Div(`class="Column"`, Label(``, "Syllables", Select("id=syllables-select", syllables...)))
+
+	// Count-in and cues
+	var countIns []interface{}
+	for _, c := range []struct{ value, name string }{{"1", "1 bar"}, {countInTwoBars, "2 bars"}, {countInReadyGo, "1, 2, ready, go"}} {
+		countIns = append(countIns, Option(fmt.Sprintf(`value="%s"`, c.value), c.name))
+	}
+	countInSelect := Div(`class="Column"`, Label(``, "Count-In", Select("id=countin-select", countIns...)))
+	markersSelect := Div(`class="Column"`, Label(``, "Markers", Select("id=markers-select", Option(`value="off"`, "off"), Option(`value="on"`, "on"))))
+
+	// Register placement
+	var registers []interface{}
+	for _, r := range []struct{ value, name string }{{"", "anywhere"}, {registerLow, "low"}, {registerMiddle, "middle"}, {registerHigh, "high"}, {registerBalanced, "balanced"}} {
+		registers = append(registers, Option(fmt.Sprintf(`value="%s"`, r.value), r.name))
+	}
+	registerSelect := Div(`class="Column"`, Label(``, "Register", Select("id=register-select", registers...)))
+
+	// Drone for Tonic Intervals
+	var drones []interface{}
+	for _, d := range []struct{ value, name string }{{"off", "off"}, {"root", "root"}, {"fifth", "root + fifth"}} {
+		drones = append(drones, Option(fmt.Sprintf(`value="%s"`, d.value), d.name))
+	}
+	droneSelect := Div(`class="Column"`, Label(``, "Drone", Select("id=drone-select", drones...)))
+	var droneSounds []interface{}
+	for _, name := range []string{"String Ensemble 1", "Church Organ", "Reed Organ", "Choir Aahs", "Pad 2 (warm)"} {
+		droneSounds = append(droneSounds, Option(fmt.Sprintf(`value="%s"`, gmSoundFileNamePrefix(name)), name))
+	}
+	droneSoundSelect := Div(`class="Column"`, Label(``, "Drone Sound", Select("id=dronesound-select", droneSounds...)))
+	var droneVolumes []interface{}
+	for _, v := range []struct{ value, name string }{{"50", "soft"}, {"80", "medium"}, {"110", "loud"}} {
+		attrs := fmt.Sprintf(`value="%s"`, v.value)
+		if v.value == "80" {
+			attrs += " selected"
+		}
+		droneVolumes = append(droneVolumes, Option(attrs, v.name))
+	}
+	droneVolumeSelect := Div(`class="Column"`, Label(``, "Drone Volume", Select("id=dronevol-select", droneVolumes...)))
+
+	// Backing chords for Chord Changes
+	var progressions []interface{}
+	for _, name := range progressionChoices {
+		progressions = append(progressions, Option(fmt.Sprintf(`value="%s"`, name), name))
+	}
+	progressionSelect := Div(`class="Column"`, Label(``, "Progression", Select("id=progression-select", progressions...)))
+	var backingSounds []interface{}
+	for _, name := range []string{"Acoustic Grand Piano", "Electric Piano 1", "Acoustic Guitar (nylon)", "Drawbar Organ", "String Ensemble 1"} {
+		backingSounds = append(backingSounds, Option(fmt.Sprintf(`value="%s"`, gmSoundFileNamePrefix(name)), name))
+	}
+	backingSoundSelect := Div(`class="Column"`, Label(``, "Backing Sound", Select("id=backingsound-select", backingSounds...)))
+	backingVolumeSelect := Div(`class="Column"`, Label(``, "Backing Volume", Select("id=backingvol-select", droneVolumes...)))
+
+	// Style of Random Melody
+	var stepChoices []interface{}
+	for _, v := range []int{50, 70, 90} {
+		attrs := fmt.Sprintf(`value="%d"`, v)
+		if v == defaultStepPercent {
+			attrs += " selected"
+		}
+		stepChoices = append(stepChoices, Option(attrs, fmt.Sprintf("%d%%", v)))
+	}
+	stepSelect := Div(`class="Column"`, Label(``, "Steps", Select("id=steps-select", stepChoices...)))
+	var leapChoices []interface{}
+	for _, v := range intervalInfo {
+		if v.size < 3 || v.size > 12 {
+			continue
+		}
+		attrs := fmt.Sprintf(`value="%s"`, v.fileName)
+		if v.fileName == defaultMaxLeap {
+			attrs += " selected"
+		}
+		leapChoices = append(leapChoices, Option(attrs, v.uiName))
+	}
+	leapSelect := Div(`class="Column"`, Label(``, "Largest Leap", Select("id=maxleap-select", leapChoices...)))
+
+	// Set classes and twelve-tone rows
+	var setClassChoices []interface{}
+	for _, sc := range setClasses {
+		setClassChoices = append(setClassChoices, Option(fmt.Sprintf(`value="%s"`, sc.forte), fmt.Sprintf("%s (%s)", sc.forte, pcString(sc.prime))))
+	}
+	setClassSelect := Div(`class="Column" id="setclass-div"`, Label(``, "Set Class", Select("id=setclass-select", setClassChoices...)))
+	rowInput := Div(`class="Column" id="row-div"`, Label(``, "Row", Input(`type="text" id="row-input" size="14" maxlength="12" placeholder="random"`)))
+	var rowFormChoices []interface{}
+	for _, f := range []struct{ value, name string }{{defaultRowForms, "P, I, R and RI"}, {"P", "P only"}, {"P-R", "P and R"}, {"P-I", "P and I"}, {"I-RI", "I and RI"}} {
+		rowFormChoices = append(rowFormChoices, Option(fmt.Sprintf(`value="%s"`, f.value), f.name))
+	}
+	rowFormsSelect := Div(`class="Column" id="rowforms-div"`, Label(``, "Row Forms", Select("id=rowforms-select", rowFormChoices...)))
+
+	// Practice history
+	userInput := Div(`class="Column" id="user-div"`, Label(``, "Your Name (optional)", Input(`type="text" id="user-input" size="12" maxlength="32"`)))
 
 	// Controls
 	playBtn := Button(`onclick="playStart()"`, "Play")
 	stopBtn := Button(`onclick="playStop()"`, "Stop")
+	replayBtn := Button(`onclick="replayEtude()"`, "Replay")
+	prevBtn := Button(`onclick="skipPattern(-1)"`, "Previous")
+	loopBtn := Button(`id="loop-button" onclick="toggleLoop()"`, "Loop")
+	nextBtn := Button(`onclick="skipPattern(1)"`, "Next")
+	patternIndex := Span(`id="pattern-index" style="padding-left:1em;"`, "")
 	downloadBtn := Button(`onclick="downloadEtude()"`, "Download")
+	historyBtn := Button(`onclick="showHistory()"`, "History")
+	tabBtn := Button(`id="tab-button" onclick="showTab()"`, "Tab")
+	answersBtn := Button(`onclick="showAnswers()"`, "Answers")
+
+	// Difficulty rating for adaptive practice
+	var grades []interface{}
+	for _, g := range []struct{ value, name string }{{"1", "hard"}, {"3", "ok"}, {"5", "easy"}} {
+		attrs := fmt.Sprintf(`value="%s"`, g.value)
+		grades = append(grades, Option(attrs, g.name))
+	}
+	gradeSelect := Div(`class="Column" id="grade-div"`, Label(``, "How did it go?", Select("id=grade-select", grades...)))
+	rateBtn := Button(`onclick="rateEtude()"`, "Rate")
+	historyRow := Div(`class="Row"`, userInput, gradeSelect, Div(`class="Column"`, rateBtn))
+	buttons := []interface{}{playBtn, stopBtn, replayBtn, downloadBtn, historyBtn, tabBtn, answersBtn}
+	if history == nil {
+		historyRow = P(`id="history-off"`, "Practice history is off on this server, so there is no name, History or rating.")
+		buttons = []interface{}{playBtn, stopBtn, replayBtn, downloadBtn, tabBtn, answersBtn}
+	}
 
 	// Assemble everything into the body element.
 	body = Body("", header,
-		Div(`class="Row" id="scale-row"`, scaleSelect, keySelect, interval1Select, interval2Select, interval3Select),
-		Div(`class="Row"`, soundSelect, metroSelect),
-		Div(`class="Row"`, tempoSelect, repeatSelect, silenceSelect),
-		Div(`style="padding-top:1vh;"`, playBtn, stopBtn, downloadBtn),
+		Div(`class="Row" id="scale-row"`, scaleSelect, keySelect, interval1Select, interval2Select, interval3Select, cellInput, setClassSelect, rowInput, rowFormsSelect),
+		Div(`class="Row"`, soundSelect, callSelect, metroSelect, clickSelect, clickVolumeSelect),
+		Div(`class="Row"`, tempoSelect, repeatSelect, silenceSelect, muteInput),
+		Div(`class="Row"`, rampSelect, rampEverySelect, dynamicsSelect, articulationSelect),
+		Div(`class="Row"`, countInSelect, markersSelect),
+		Div(`class="Row"`, tuningSelect, tuningModeSelect, registerSelect, syllablesSelect),
+		Div(`class="Row" id="direction-row"`, directionSelect, contourInput),
+		Div(`class="Row" id="drone-row"`, droneSelect, droneSoundSelect, droneVolumeSelect),
+		Div(`class="Row" id="backing-row"`, progressionSelect, backingSoundSelect, backingVolumeSelect),
+		Div(`class="Row" id="melody-row"`, stepSelect, leapSelect),
+		Div(`class="Row" id="fretboard-row"`, positionSelect, stringsInput),
+		historyRow,
+		Div(`style="padding-top:1vh;"`, buttons...),
+		Div(`style="padding-top:1vh;"`, prevBtn, loopBtn, nextBtn, patternIndex),
 		quickStart(),
 		forTheCurious(),
 		toTop(),
@@ -231,6 +452,46 @@ func forTheCurious() (div *HtmlTree) {
 	different pitch so that all 12 pitches are covered. <em>Note: For brevity, the
 	score examples shown here are captured with a repeat count of zero.</em>`
 
+	p15a := `<strong>Adaptive</strong> builds an etude of 24 one and two
+	interval patterns chosen for you. Intervals you've rated as hard and
+	intervals that are due for review turn up more often than ones you've
+	rated as easy. The schedule follows the spaced repetition method: each
+	time you rate an interval as ok or easy, the wait before it comes due
+	again grows. Rating it hard starts it over. Adaptive needs your name; see
+	<a href="#ui">User Interface</a>.`
+
+	p15b := `<strong>Chord Changes</strong> plays a chord progression such
+	as I-IV-V-I or ii-V-I in the key of the Tonal Center, one chord per
+	pattern, on a backing track. Each pattern is the three notes of the
+	current chord in a random order, so you learn to hear and play the chord
+	tones as the harmony moves under you. Choose the progression, the
+	backing sound and its volume with the selectors that appear when you
+	choose this pattern.`
+
+	p15c := `<strong>Random Melody</strong> plays 12 short melodies in the
+	key of the Tonal Center for ear training and dictation: listen to each
+	one, then play or sing it back, or write it down. Each melody has 8 notes
+	over two bars, starts on the tonic, third or fifth, and ends on the
+	tonic. They follow the habits of real tunes: mostly steps, a step back
+	the other way after a big leap, the leading tone rising to the tonic and
+	the fourth falling to the third. The Steps selector sets how many of the
+	moves are steps rather than leaps and the Largest Leap selector limits
+	the size of the leaps.`
+
+	p15d := `<strong>Set Class</strong> is for contemporary music. Choose a
+	trichord or tetrachord by its Forte number; its prime form is shown in
+	parentheses, e.g. "3-11 (037)", the major and minor triads. The etude
+	plays the prime form and its inversion starting on every pitch, 24
+	patterns in all, each in a different note order as in the Three
+	Intervals pattern.`
+
+	p15e := `<strong>Tone Row</strong> plays 12 forms of a twelve-tone row:
+	the prime (P), inversion (I), retrograde (R) and retrograde inversion
+	(RI), each at a random transposition. Type the row in the Row box using 0
+	to 9, t and e for the 12 pitch classes, e.g. 0e37t2546918, or leave it
+	empty for a random row. The Row Forms selector picks which forms to
+	practice. Each form takes three bars.`
+
 	p15 := `<strong>Tonic Intervals</strong> presents 13 different intervals,
 	i.e., all possible pitches relative to the chosen tonic pitch. Use this
 	pattern as a self-test to gauge your progress at distinguishing the
@@ -253,6 +514,14 @@ func forTheCurious() (div *HtmlTree) {
 	constructed with a 2-2-1 pattern of half steps, corresponding to the
 	first 4 notes of a major scale.`
 
+	p17a := `<strong>Interval Cell</strong> takes the idea further: type 2 to 7
+	interval sizes in half steps in the Cell Intervals box, e.g. "3 2 5 1",
+	to practice cells of up to 8 notes. The etude has 24 patterns, two
+	starting on each pitch. Five or more notes can be played in more orders
+	than there are patterns, so each pattern gets a different order chosen
+	at random. Cells longer than 4 notes continue into the next bar, so a
+	pattern and each of its repeats take two bars.`
+
 	div = Div("",
 		H3("", heading),
 		P("", p0),
@@ -273,6 +542,18 @@ func forTheCurious() (div *HtmlTree) {
 		H4("", "Three Intervals"),
 		P("", p17),
 		Img(`src="img/three_interval_excerpt.png" class="example"`),
+		H4("", "Interval Cell"),
+		P("", p17a),
+		H4("", "Adaptive"),
+		P("", p15a),
+		H4("", "Chord Changes"),
+		P("", p15b),
+		H4("", "Random Melody"),
+		P("", p15c),
+		H4("", "Set Class"),
+		P("", p15d),
+		H4("", "Tone Row"),
+		P("", p15e),
 	)
 	return
 }
@@ -336,6 +617,11 @@ func forTheSerious() (div *HtmlTree) {
 		Li(``, `Third Inversion 7ths: 1-4-3, 2-4-3, 1-3-4, 2-3-3, 2-4-4`),
 	)
 
+	p7 := `The progression above is also available as a lesson plan, <a
+	href="/lesson/serious">For the Serious</a>, with a page for each step and a
+	way to track your progress. Teachers can write their own lesson plans; see
+	<a href="#custom">Customizing</a>.`
+
 	div = Div("",
 		H3("", "For the serious"),
 		P("", p0),
@@ -349,6 +635,7 @@ func forTheSerious() (div *HtmlTree) {
 		H4(``, `Three Intervals (4 notes)`),
 		P("", p6),
 		ul2,
+		P("", p7),
 	)
 	return
 }
@@ -385,6 +672,10 @@ func forBeginners() (div *HtmlTree) {
 	the Tonic Intervals pattern and a random Tonal Center. Try to wait until
 	you're getting most of the intervals right on the first repeat before moving
 	to the Two Intervals pattern.`
+
+	p5 := `The lesson plan <a href="/lesson/beginner">For Beginners</a> lays
+	out these steps one at a time and keeps track of your progress if you
+	enter your name.`
 	div = Div("",
 		A(`name="beginners"`, H3("", "For Beginners")),
 		P("", p1),
@@ -392,6 +683,7 @@ func forBeginners() (div *HtmlTree) {
 		P("", p2),
 		P("", p3),
 		P("", p4),
+		P("", p5),
 	)
 	return
 }
@@ -399,9 +691,18 @@ func forVocalists() (div *HtmlTree) {
 	p1 := `I conceived Infinite Etudes as an aid for instrumentalists. I've since
 	found it's also quite useful as a daily vocal workout for intonation. The
 	instrument selection menu has choir ahh sounds for soprano, alto, tenor and bass ranges.`
+	p2 := `Choose movable do, fixed do or scale degrees with the Syllables
+	selector to put a syllable under every note as a MIDI lyric. Players
+	that show lyrics, like MuseScore or a karaoke player, display them as
+	the etude plays. Movable do and scale degrees count from the Tonal
+	Center, or from the first note of each pattern in patterns without one.
+	Muted bars have no syllables, so you have to work them out. After
+	playing an etude, the Answers button shows every pattern's notes and
+	syllables so you can check what you sang or took down.`
 	div = Div("",
 		H3("", "For Vocalists"),
 		P("", p1),
+		P("", p2),
 	)
 	return
 }
@@ -413,8 +714,11 @@ func userInterface() (div *HtmlTree) {
 	Interval3), appear according to the number intervals in the chosen
 	pattern. The interval choices are labeled by the number of semitones
 	(half steps) and the corresponding musical name, e.g. "4 (Minor Third)".
-	The Tonal Center selector appears only when the Tonic Intervals pattern
-	is selected.`
+	Beyond the octave are the compound intervals, ninths through thirteenths,
+	for wide leap training. They're kept intact rather than folded into the
+	octave, so the intervals you pick must fit together within the range of
+	your instrument. The Tonal Center selector appears only when the Tonic
+	Intervals pattern is selected.`
 
 	p2 := `The Instrument selector provides a choice of common instrument sounds. Your choice also
 	determines the range of pitches that can occur within an etude.`
@@ -425,28 +729,158 @@ func userInterface() (div *HtmlTree) {
 	the first pitch of each sequence is "close" to the preceding pitch
 	without wandering outside the playable range of your instrument.`
 
+	p2b := `The Call Sound selector plays the first measure of each pattern,
+	the call, with a different sound from the repeats that follow, the
+	response. Hearing a pattern on the piano and answering on your own
+	instrument is how many teachers run a lesson. Combine it with a custom
+	mute pattern like PM or PMM to leave the response to you.`
+
 	p3 := `By default the metronome gives an initial 1 measure count-in and
 	continues to click on each beat of the etude.  You can control this with the
 	Metronome selector. Choose "downbeat" to have it click only on beat 1 of each measure.
-	Choose "off" for silence after the count-in.`
+	Choose "off" for silence after the count-in. Choose "eighths" or
+	"triplets" to hear softer clicks between the beats, a good way to keep
+	your rhythm even at slow tempos. Choose "2 and 4" to hear only the
+	backbeat, the way a jazz drummer's hi-hat sounds.`
+
+	p3b := `The gap settings test whether you can hold the pulse on your
+	own. With "growing gaps" the metronome clicks for two bars, drops out
+	for one, clicks for two more, drops out for two, and so on, up to four
+	silent bars at a time. With "random gaps" it drops out for bars chosen
+	at random. Either way, when the click comes back you'll hear whether
+	you've rushed or dragged.`
+
+	p3c := `The Count-In selector sets what you hear before the first
+	pattern: one bar of clicks, two bars, or a conductor's count-off of two
+	slow clicks and a bar of four, "1, 2, 1, 2, ready, go". Set Markers to on
+	to name each pattern and its intervals, e.g. "Pattern 3" and "up major3,
+	down minor3", with marker and text events where the pattern starts, and
+	to spell out the count-in. DAWs and MuseScore list the markers so you can
+	jump straight to a pattern or loop it.`
+
+	p3a := `The Click Sound selector chooses the metronome's percussion
+	sound and the Click Volume selector sets how loud it is compared to the
+	instrument.`
 
 	p4 := `Infinite Etudes generates MIDI files in 4/4 time with the tempo
 	defaulted to 120 beats per minute. If you need it slower or faster, use
 	the Tempo selector to choose a value between 60 and 480 beats per
 	minute.`
 
+	p4a := `To push your speed within a session, choose a faster tempo with
+	the Speed Up To selector. The etude starts at the Tempo setting and
+	speeds up in even steps, reaching the Speed Up To tempo at the last step.
+	The Every selector sets how often the tempo steps up: on every bar, or
+	at the start of every pattern or every few patterns. Choose "off" for a
+	steady tempo.`
+
+	p4b := `With the Tonic Intervals pattern, the Drone selector adds a
+	sustained tone on the tonal center (or on the tonal center and the fifth
+	above it) under the etude. Listening against a drone is one of the best
+	ways to train your intonation. Drone Sound and Drone Volume choose the
+	sound and how loud it plays.`
+
+	p4c := `The Dynamics selector shapes how loudly the notes are played.
+	"crescendo" gets louder with each repeat of a pattern, "random accents"
+	stresses notes at random and "terraced" plays each pattern at its own
+	level: soft, medium or loud. The Articulation selector sets how the notes
+	are joined: "staccato" notes are short and detached, "tenuto" notes are
+	held for their full value with a little extra weight and "legato" notes
+	overlap slightly. Match them on your instrument.`
+
+	p4d := `The Tuning selector plays the etude in a tuning other than equal
+	temperament. Just intonation, Pythagorean and quarter-comma meantone
+	tune each note relative to the Tonal Center, or to the first note of
+	each pattern when there's no Tonal Center. The quarter tone sharp
+	setting raises every note but those of that reference pitch by a
+	quarter tone, so intervals from the reference, such as a minor third,
+	become neutral while octaves stay in tune. It isn't full 24-tone equal
+	temperament: intervals between two other notes are unchanged. The
+	Retune With selector chooses how: pitch bend works with almost any
+	player, including this page's; the MIDI Tuning Standard is better for
+	downloads played on synthesizers that support it. Either way, only the
+	etude's own notes are retuned; drones and backing chords stay in equal
+	temperament.`
+
+	p4f := `The Register selector chooses where in your instrument's range
+	the patterns are played. By default each pattern starts near the last
+	note of the one before, so an etude may wander and spend most of its time
+	high or low. Choose "low", "middle" or "high" to keep the patterns in that
+	third of the range, good for deliberate work on the extremes of a wind
+	instrument. Choose "balanced" to move smoothly from low to high and back,
+	with the same number of patterns in each third.`
+
+	p4g := `For guitars and basses, the Position selector keeps every note
+	within reach of one hand position, numbered by the fret under your index
+	finger. Each position covers five frets, from the fret behind your index
+	finger to the one under your little finger, so the first position
+	includes the open strings. Type string numbers in the Strings box to play
+	only on those strings, counting from 1 for the highest: "123" for the top
+	three strings of a guitar, "34" for the lower two of a bass. A pattern
+	that can't be played entirely in position is placed where most of its
+	notes can be. After playing an etude, the Tab button shows it as
+	tablature, one staff for each pattern, with fingerings in the position
+	and strings you chose.`
+
+	p4e := `For the One Interval, Two Intervals and Three Intervals patterns,
+	the Direction selector controls which way the notes move. "mixed" plays
+	them in random orders, "ascending" always moves up and "descending" always
+	moves down, a good way to work on descending intervals, which most people
+	find harder. Choose "contour" to type a shape in the Contour box, one
+	letter for each step between notes: u for up, d for down. For example,
+	"ud" plays Two Intervals patterns up then down and "dud" plays Three
+	Intervals patterns down, up, down. One Interval patterns always return to
+	their first note, so their contour is "ud" or "du".`
+
 	p5 := `Use the Repeats selector to change the number of repeats for each sequence. The default is
 	3. You can set it to 2 or 1 to increase the challenge. You can also set it to 0, but that's
-	not useful unless you want to download an example to import into a score editor.`
+	not useful unless you want to download an example to import into a score editor.
+	If you need more time with each pattern, choose up to 8 repeats.`
 
 	p6 := `The Muting selector allows you silence one or more of the repeated
 	measures. The cross mark symbol, &#x2717;, indicates a silent measure and
-	the check mark, &#x2713;, indicates an audible one.`
+	the check mark, &#x2713;, indicates an audible one. These settings apply
+	to the first three repeats. Choose "random" to have the server mute
+	repeats at random, so you have to fill in from memory. Choose "custom" to
+	type your own mute pattern in the Mute Pattern box, one letter for each
+	measure of the pattern: P to play it or M to mute it. For example, with 5
+	repeats, PPMMPM plays the pattern twice, mutes two measures, plays it once
+	more and mutes the last measure. The first letter must be P.`
 
 	p7 := `The Play button tells the server to generate and start playing a
 	new etude using the settings you've chosen in the the selectors. The Stop
 	button stops the playback before the end of the etude. The Download
-	button generates a new etude and allows you to save it as a MIDI file.`
+	button generates a new etude and allows you to save it as a MIDI file.
+	The page plays etudes with simple synthesized sounds so that they start
+	at once; for realistic instrument sounds, download the etude and open it
+	in a MIDI player.`
+
+	p7a := `The Replay button plays the etude you just heard again at the
+	tempo now shown in the Tempo selector. If an etude was too fast, slow
+	it down and replay it until it's comfortable, then try it at the
+	original tempo. The server remembers each etude for an hour.`
+
+	p7b := `While an etude plays, the player shows which pattern you're on.
+	The Loop button repeats that pattern until you press it again (it reads
+	Stop Looping meanwhile), then carries on with the rest of the etude. The
+	Previous and Next buttons jump back or ahead a pattern; if you're
+	looping, they loop the new pattern instead. Each jump starts with the
+	count-in so you have time to get ready, and plays at the tempo in the
+	Tempo selector.`
+
+	p8 := `If you enter a name in the Your Name box, the server keeps a
+	record of each etude you play or download. The History button shows your
+	record: the etudes you've played, your current and longest streaks of
+	consecutive practice days and the time you've spent on each interval.
+	Leave the box empty if you don't want a record kept. Servers run without a
+	history database leave out the name box, the History and Rate buttons and
+	the Adaptive pattern.`
+
+	p9 := `After playing a One Interval, Two Intervals or Adaptive etude,
+	use the How did it go? selector and the Rate button to tell the server
+	whether the intervals (or pairs of intervals) it drilled were hard, ok
+	or easy for you. The Adaptive pattern uses your ratings to decide what
+	to give you next.`
 
 	div = Div("",
 		A(`name="ui"`, H3("", "User Interface")),
@@ -455,16 +889,45 @@ func userInterface() (div *HtmlTree) {
 		H4("", "Instrument"),
 		P("", p2),
 		P("", p2a),
+		H4("", "Call Sound"),
+		P("", p2b),
 		H4("", "Metronome"),
 		P("", p3),
+		P("", p3b),
+		H4("", "Count-In, Markers"),
+		P("", p3c),
+		H4("", "Click Sound, Click Volume"),
+		P("", p3a),
 		H4("", "Tempo"),
 		P("", p4),
+		H4("", "Speed Up To, Every"),
+		P("", p4a),
+		H4("", "Dynamics, Articulation"),
+		P("", p4c),
+		H4("", "Tuning, Retune With"),
+		P("", p4d),
+		H4("", "Register"),
+		P("", p4f),
+		H4("", "Position, Strings, Tab"),
+		P("", p4g),
+		H4("", "Direction, Contour"),
+		P("", p4e),
+		H4("", "Drone"),
+		P("", p4b),
 		H4("", "Repeats"),
 		P("", p5),
 		H4("", "Muting"),
 		P("", p6),
 		H4("", "Play, Stop, Download"),
 		P("", p7),
+		H4("", "Replay"),
+		P("", p7a),
+		H4("", "Previous, Loop, Next"),
+		P("", p7b),
+		H4("", "Your Name, History"),
+		P("", p8),
+		H4("", "Rating"),
+		P("", p9),
 	)
 	return
 }
@@ -486,11 +949,21 @@ func custom() (div *HtmlTree) {
 	href="https://github.com/Michael-F-Ellis/infinite-etudes">GitHub.</a> and
 	adapt the program to your needs.`
 
+	p4 := `Teachers can sequence etudes into lesson plans. A lesson plan is a
+	JSON file in the server's lessons directory listing the steps in order.
+	Each step gives a title and notes, the etude pattern, tonal center or
+	intervals, a range of tempos, the number of repeats and muting, and the
+	criteria for advancing: a number of etudes played at or above a tempo on
+	a number of different days. The server shows each step at
+	/lesson/&lt;plan&gt;/&lt;step&gt;. See the plans that come with the
+	source code for examples.`
+
 	div = Div("",
-		H3("", "Customizing"),
+		A(`name="custom"`, H3("", "Customizing")),
 		P("", p1),
 		P("", p2),
 		P("", p3),
+		P("", p4),
 	)
 	return
 }
@@ -512,7 +985,7 @@ func variations() (div *HtmlTree) {
 		`Play it up or down a fifth (or fourth, third, ...).`,
 		`Play it as a chord.`,
 		`Find a bass note or chord that works with the sequence.`,
-		`Mess with the rhythm, accents, dynamics, timbre, ...`,
+		`Mess with the rhythm, accents, dynamics, timbre, ... (the Dynamics and Articulation selectors will get you started)`,
 		`Shred it in sixteenth note cross-rhythm, e.g. 1231 2312 3123`,
 		`Fill in between the notes.`,
 		`Invent a counter-melody,`,
@@ -715,16 +1188,24 @@ func indexJS() (script *HtmlTree) {
 		function start() {
 		  // Chrome and other browsers now disallow AudioContext until
 		  // after a user action.
-		  document.body.addEventListener("click", MIDIjs.resumeAudioContext);
+		  document.body.addEventListener("click", function() {
+			audioContext().resume()
+		  })
 		  var scaleselect = document.getElementById("scale-select")
 		  scaleselect.addEventListener("change", manageInputs)
 		  manageInputs()
 		}
 		// returns true if the selected key is an interval name
 		function isIntervalName(name) {
-			var inames = ['minor2', 'major2', 'minor3', 'major3', 'perfect4', 'tritone',
-			'perfect5', 'minor6', 'major6', 'minor7', 'major7', 'octave']
-			return inames.includes(name)
+			return intervalNames().includes(name)
+		}
+		// intervalNames returns the interval names from a minor 2nd up, in
+		// order of size.
+		function intervalNames() {
+			return ['minor2', 'major2', 'minor3', 'major3', 'perfect4', 'tritone',
+			'perfect5', 'minor6', 'major6', 'minor7', 'major7', 'octave',
+			'minor9', 'major9', 'minor10', 'major10', 'perfect11', 'augmented11',
+			'perfect12', 'minor13', 'major13']
 		}
 		// manageInputs adjusts the enable status of the key and interval widgets
 		// when scale-select value changes
@@ -734,6 +1215,22 @@ func indexJS() (script *HtmlTree) {
 			var interval2 = document.getElementById("interval2-div")
 			var interval3 = document.getElementById("interval3-div")
 			var scalePattern = document.getElementById("scale-select").value
+			// drones sustain the tonal center of Tonic Intervals
+			document.getElementById("drone-row").style.display = scalePattern == "allintervals" ? "" : "none"
+			document.getElementById("backing-row").style.display = scalePattern == "changes" ? "" : "none"
+			document.getElementById("melody-row").style.display = scalePattern == "melody" ? "" : "none"
+			// fretboard positions and tablature apply to guitars and basses
+			var fretted = document.getElementById("sound-select").selectedOptions[0].dataset.strings ? "" : "none"
+			document.getElementById("fretboard-row").style.display = fretted
+			document.getElementById("tab-button").style.display = fretted
+			document.getElementById("mute-div").style.display = document.getElementById("silence-select").value == "custom" ? "" : "none"
+			// direction applies to the interval patterns
+			document.getElementById("direction-row").style.display = scalePattern.startsWith("interval") ? "" : "none"
+			document.getElementById("contour-div").style.display = document.getElementById("direction-select").value == "contour" ? "" : "none"
+			document.getElementById("cell-div").style.display = scalePattern == "intervalcell" ? "" : "none"
+			document.getElementById("setclass-div").style.display = scalePattern == "setclass" ? "" : "none"
+			document.getElementById("row-div").style.display = scalePattern == "tonerow" ? "" : "none"
+			document.getElementById("rowforms-div").style.display = scalePattern == "tonerow" ? "" : "none"
 			if (scalePattern == "interval") {
 				interval1.style.display=""
 				interval2.style.display="none"
@@ -755,6 +1252,13 @@ func indexJS() (script *HtmlTree) {
 				key.style.display="none"
 				return
 			}
+			if (["adaptive", "intervalcell", "setclass", "tonerow"].includes(scalePattern)) {
+				interval1.style.display="none"
+				interval2.style.display="none"
+				interval3.style.display="none"
+				key.style.display="none"
+				return
+			}
 			// all the other patterns are chosen by key
 			interval1.style.display="none"
 			interval2.style.display="none"
@@ -773,15 +1277,169 @@ func indexJS() (script *HtmlTree) {
 		  if (key=="random") {
 			  key=randomKey()
 			  };
+		  if (scale == "adaptive" && userName() == "") {
+			  alert("Enter your name to play Adaptive etudes.")
+			  return ""
+		  }
 		  interval1 = document.getElementById("interval1-select").value
 		  interval2 = document.getElementById("interval2-select").value
 		  interval3 = document.getElementById("interval3-select").value
 		  sound = document.getElementById("sound-select").value
-		  metronome = document.getElementById("metro-select").value
+		  metronome = metronomeSegment()
 		  tempo = document.getElementById("tempo-select").value
 		  repeats = document.getElementById("repeat-select").value
-		  silent = document.getElementById("silence-select").value
-		  return "/etude/" + key + "/" + scale + "/" + interval1 + "/" + interval2 + "/" + interval3 + "/" + sound + "/" + metronome + "/" + tempo + "/" + repeats + "/" + silent
+		  silent = muteSegment()
+		  if (silent == "") {
+			  return ""
+		  }
+		  if (scale == "intervalcell" && cellNames() == "") {
+			  return ""
+		  }
+		  return "/etude/" + key + "/" + scale + "/" + interval1 + "/" + interval2 + "/" + interval3 + "/" + sound + "/" + metronome + "/" + tempo + "/" + repeats + "/" + silent + etudeQuery()
+		}
+
+		// cellNames returns the interval names for the Interval Cell pattern,
+		// joined by "-", from the sizes in half steps typed in the Cell
+		// Intervals box. It returns "" if the sizes are bad.
+		function cellNames() {
+		  var inames = ['unison'].concat(intervalNames())
+		  var sizes = document.getElementById("cell-input").value.trim().split(/[\s,]+/)
+		  var names = []
+		  for (var size of sizes) {
+			  if (!/^\d+$/.test(size) || parseInt(size) >= inames.length) {
+				  names = []
+				  break
+			  }
+			  names.push(inames[parseInt(size)])
+		  }
+		  if (names.length < 2 || names.length > 7) {
+			  alert("Type 2 to 7 interval sizes in half steps (0 to " + (inames.length-1) + "), e.g. 3 2 5 1.")
+			  return ""
+		  }
+		  return names.join("-")
+		}
+
+		// metronomeSegment returns the metronome part of the etude URL,
+		// mode[-sound[-volume]], leaving out the defaults.
+		function metronomeSegment() {
+		  var segment = document.getElementById("metro-select").value
+		  var click = document.getElementById("click-select").value
+		  var volume = document.getElementById("clickvol-select").value
+		  if (click != "woodblock" || volume != "") {
+			  segment += "-" + click
+		  }
+		  if (volume != "") {
+			  segment += "-" + volume
+		  }
+		  return segment
+		}
+
+		// muteSegment returns the muting part of the etude URL: the selected
+		// mask, "random" or a custom mute pattern with one P (play) or M
+		// (mute) for each bar. It returns "" if the custom pattern is bad.
+		function muteSegment() {
+		  var silent = document.getElementById("silence-select").value
+		  if (silent != "custom") {
+			  return silent
+		  }
+		  var pattern = document.getElementById("mute-input").value.trim().toUpperCase()
+		  var bars = 1 + parseInt(document.getElementById("repeat-select").value)
+		  if (!/^P[PM]*$/.test(pattern) || pattern.length != bars) {
+			  alert("The mute pattern needs " + bars + " letters, P to play or M to mute, starting with P.")
+			  return ""
+		  }
+		  return pattern
+		}
+
+		// etudeQuery returns the query string for optional etude settings.
+		function etudeQuery() {
+		  var params = new URLSearchParams()
+		  var user = userName()
+		  if (user != "") {
+			  params.set("user", user)
+		  }
+		  var ramp = document.getElementById("ramp-select").value
+		  if (ramp != "off") {
+			  params.set("rampto", ramp)
+			  params.set("rampevery", document.getElementById("rampevery-select").value)
+		  }
+		  var drone = document.getElementById("drone-select").value
+		  if (document.getElementById("scale-select").value == "allintervals" && drone != "off") {
+			  params.set("drone", drone)
+			  params.set("dronesound", document.getElementById("dronesound-select").value)
+			  params.set("dronevol", document.getElementById("dronevol-select").value)
+		  }
+		  for (var name of ["dynamics", "articulation"]) {
+			  var value = document.getElementById(name + "-select").value
+			  if (value != "") {
+				  params.set(name, value)
+			  }
+		  }
+		  var tuning = document.getElementById("tuning-select").value
+		  if (tuning != "") {
+			  params.set("tuning", tuning)
+			  params.set("tuningmode", document.getElementById("tuningmode-select").value)
+		  }
+		  if (document.getElementById("sound-select").selectedOptions[0].dataset.strings) {
+			  var position = document.getElementById("position-select").value
+			  if (position != "0") {
+				  params.set("position", position)
+			  }
+			  var strs = document.getElementById("strings-input").value.replace(/[^0-9]/g, "")
+			  if (strs != "") {
+				  params.set("strings", strs)
+			  }
+		  }
+		  var countin = document.getElementById("countin-select").value
+		  if (countin != "1") {
+			  params.set("countin", countin)
+		  }
+		  if (document.getElementById("markers-select").value == "on") {
+			  params.set("markers", "on")
+		  }
+		  var syllables = document.getElementById("syllables-select").value
+		  if (syllables != "") {
+			  params.set("syllables", syllables)
+		  }
+		  var register = document.getElementById("register-select").value
+		  if (register != "") {
+			  params.set("register", register)
+		  }
+		  var direction = document.getElementById("direction-select").value
+		  if (document.getElementById("scale-select").value.startsWith("interval") && direction != "mixed") {
+			  if (direction == "contour") {
+				  direction = document.getElementById("contour-input").value.trim().toLowerCase()
+			  }
+			  params.set("direction", direction)
+		  }
+		  var call = document.getElementById("call-select").value
+		  if (call != "") {
+			  params.set("call", call)
+		  }
+		  if (document.getElementById("scale-select").value == "intervalcell") {
+			  params.set("cell", cellNames())
+		  }
+		  if (document.getElementById("scale-select").value == "setclass") {
+			  params.set("setclass", document.getElementById("setclass-select").value)
+		  }
+		  if (document.getElementById("scale-select").value == "tonerow") {
+			  var row = document.getElementById("row-input").value.trim().toLowerCase()
+			  if (row != "") {
+				  params.set("row", row)
+			  }
+			  params.set("rowforms", document.getElementById("rowforms-select").value)
+		  }
+		  if (document.getElementById("scale-select").value == "melody") {
+			  params.set("steps", document.getElementById("steps-select").value)
+			  params.set("maxleap", document.getElementById("maxleap-select").value)
+		  }
+		  if (document.getElementById("scale-select").value == "changes") {
+			  params.set("progression", document.getElementById("progression-select").value)
+			  params.set("backingsound", document.getElementById("backingsound-select").value)
+			  params.set("backingvol", document.getElementById("backingvol-select").value)
+		  }
+		  var q = params.toString()
+		  return q == "" ? "" : "?" + q
 		}
 
 		// Read the selects and returns a proposed filename for the etude to be downloaded.
@@ -795,10 +1453,10 @@ func indexJS() (script *HtmlTree) {
 		  interval2 = document.getElementById("interval2-select").value
 		  interval3 = document.getElementById("interval3-select").value
 		  sound = document.getElementById("sound-select").value
-		  metronome = document.getElementById("metro-select").value
+		  metronome = metronomeSegment()
 		  tempo = document.getElementById("tempo-select").value
 		  repeats = document.getElementById("repeat-select").value
-		  silent = document.getElementById("silence-select").value
+		  silent = muteSegment()
 		  if (scale=="interval"){
 			  return scale + "_" + interval1 + "_" + sound + "_" + metronome + "_" + tempo + "_" + repeats  + "_"+ silent + ".midi" 
 		  }
@@ -808,6 +1466,9 @@ func indexJS() (script *HtmlTree) {
 		  if (scale=="intervaltriple"){
 			  return scale + "_" + interval1 + "_" + interval2 + "_"  + interval3 + "_" + sound + "_" + metronome + "_" + tempo + "_" + repeats  + "_" + silent + ".midi" 
 		  }
+		  if (scale=="intervalcell"){
+			  return scale + "_" + cellNames() + "_" + sound + "_" + metronome + "_" + tempo + "_" + repeats  + "_" + silent + ".midi"
+		  }
 		  // any other scale 
 		  return key + "_" + scale + "_" + sound + "_" + metronome + "_" + tempo + "_" + repeats  + "_" + silent + ".midi"
 		}
@@ -819,18 +1480,249 @@ func indexJS() (script *HtmlTree) {
 			return keys[Math.floor(Math.random() * keys.length)]
 		}
 
+		// lastSeed identifies the last etude played so it can be replayed.
+		var lastSeed = ""
+
+		// The last etude's event list from the server, the tempo it was
+		// fetched at ("" for the etude's own tempo) and its pattern start
+		// times in seconds with the end of the last one. Then the number of
+		// patterns in the etude, the index of the pattern being played and
+		// of the pattern being looped, or -1.
+		var etudeEvents = null
+		var eventsTempo = ""
+		var patternStarts = []
+		var patternCount = 0
+		var currentPattern = 0
+		var loopPattern = -1
+
 		function playStart() {
-			MIDIjs.stop()
 			var url = etudeURL()
-			if (url != "") {
-			  MIDIjs.play(url)
+			if (url == "") {
+			  return
+			}
+			stopEvents()
+			loopPattern = -1
+			fetch(url)
+			  .then(function(resp) {
+				if (!resp.ok) {
+				  throw new Error("etude request failed: " + resp.status)
+				}
+				lastSeed = resp.headers.get("X-Etude-Seed") || ""
+				return loadEvents("")
+			  })
+			  .then(function() {
+				playPatterns(0)
+			  })
+			  .catch(function(err) {
+				alert(err.message)
+			  })
+		}
+
+		// loadEvents fetches the event list of the last etude at tempo, or
+		// at its own tempo if tempo is "".
+		function loadEvents(tempo) {
+			var url = "/events/" + lastSeed
+			if (tempo != "") {
+			  url += "?tempo=" + tempo
+			}
+			return fetch(url)
+			  .then(function(resp) {
+				if (!resp.ok) {
+				  throw new Error("event list request failed: " + resp.status)
+				}
+				return resp.json()
+			  })
+			  .then(function(events) {
+				etudeEvents = events
+				eventsTempo = tempo
+				patternStarts = events.starts
+				patternCount = Math.max(patternStarts.length - 1, 0)
+			  })
+		}
+
+		// playPatterns plays the last etude from pattern from, or loops
+		// that pattern if loopPattern is set. Except when playing the
+		// whole etude, the count-in comes first.
+		function playPatterns(from) {
+			var s = patternStarts
+			var end = s[s.length - 1]
+			currentPattern = from
+			if (from == 0 && loopPattern < 0) {
+			  playEvents(etudeEvents, [{from: 0, to: end}], false, showPattern)
+			  return
+			}
+			var countIn = {from: 0, to: s[0]}
+			if (loopPattern >= 0) {
+			  playEvents(etudeEvents, [countIn, {from: s[from], to: s[from + 1]}], true, showPattern)
+			  return
+			}
+			playEvents(etudeEvents, [countIn, {from: s[from], to: end}], false, showPattern)
+		}
+
+		// playAtTempo plays from (or loops) pattern from of the last etude
+		// at the selected tempo, fetching its event list again if the
+		// tempo has changed.
+		function playAtTempo(from) {
+			var tempo = document.getElementById("tempo-select").value
+			if (tempo == eventsTempo) {
+			  playPatterns(from)
+			  return
+			}
+			stopEvents()
+			loadEvents(tempo)
+			  .then(function() {
+				playPatterns(from)
+			  })
+			  .catch(function(err) {
+				alert(err.message)
+			  })
+		}
+
+		// showPattern shows the pattern being played at time t in the
+		// etude.
+		function showPattern(t) {
+			if (patternStarts.length < 2) {
+			  return
+			}
+			// during a count-in, show the pattern to come
+			if (t >= patternStarts[0] && loopPattern < 0) {
+			  var i = 0
+			  while (i + 2 < patternStarts.length && t >= patternStarts[i + 1]) {
+				i++
+			  }
+			  currentPattern = i
+			}
+			var label = "Pattern " + (currentPattern + 1) + " of " + patternCount
+			if (loopPattern >= 0) {
+			  label += " (looping)"
+			}
+			document.getElementById("pattern-index").innerHTML = label
+		}
+
+		// toggleLoop starts or stops looping the pattern being played.
+		function toggleLoop() {
+			if (lastSeed == "" || patternCount == 0) {
+			  alert("Play an etude first.")
+			  return
+			}
+			if (loopPattern >= 0) {
+			  loopPattern = -1
+			  document.getElementById("loop-button").innerHTML = "Loop"
+			  playAtTempo(currentPattern)
+			  return
+			}
+			loopPattern = currentPattern
+			document.getElementById("loop-button").innerHTML = "Stop Looping"
+			playAtTempo(loopPattern)
+		}
+
+		// skipPattern plays from the pattern step patterns after (or,
+		// if step is negative, before) the one being played. A looped
+		// pattern stays looped.
+		function skipPattern(step) {
+			if (lastSeed == "" || patternCount == 0) {
+			  alert("Play an etude first.")
+			  return
+			}
+			var next = Math.min(Math.max(currentPattern + step, 0), patternCount - 1)
+			currentPattern = next
+			if (loopPattern >= 0) {
+			  loopPattern = next
+			}
+			playAtTempo(next)
+		}
+
+		// replayEtude plays the last etude again at the selected tempo.
+		function replayEtude() {
+			if (lastSeed == "") {
+			  alert("Play an etude first.")
+			  return
 			}
+			loopPattern = -1
+			document.getElementById("loop-button").innerHTML = "Loop"
+			playAtTempo(0)
 		}
 
 		function playStop() {
-		    MIDIjs.stop()
+		    loopPattern = -1
+		    document.getElementById("loop-button").innerHTML = "Loop"
+		    stopEvents()
 		}
         
+		// showAnswers opens the answer sheet for the last etude played.
+		function showAnswers() {
+		  if (lastSeed == "") {
+			  alert("Play an etude first.")
+			  return
+		  }
+		  window.open("/answers/" + lastSeed)
+		}
+
+		// showTab opens the tablature for the last etude played.
+		function showTab() {
+		  if (lastSeed == "") {
+			  alert("Play an etude first.")
+			  return
+		  }
+		  window.open("/tab/" + lastSeed)
+		}
+
+		// userName returns the name in user-input, or "" when the server
+		// keeps no practice history and leaves the name out of the page.
+		function userName() {
+		  var input = document.getElementById("user-input")
+		  return input ? input.value.trim() : ""
+		}
+
+		// showHistory opens the practice history for the name in user-input.
+		function showHistory() {
+		  var user = userName()
+		  if (user == "") {
+			  alert("Enter your name to see your practice history.")
+			  return
+		  }
+		  window.open("/history/" + encodeURIComponent(user))
+		}
+
+		// rateEtude grades the review cards drilled by the last etude
+		// played: its interval or interval pair, or the cards an adaptive
+		// etude was drawn from.
+		function rateEtude() {
+		  var user = userName()
+		  if (user == "") {
+			  alert("Enter your name to rate etudes.")
+			  return
+		  }
+		  if (lastSeed == "") {
+			  alert("Play an etude first.")
+			  return
+		  }
+		  var grade = document.getElementById("grade-select").value
+		  fetch("/cards/" + lastSeed)
+			.then(function(resp) {
+			  if (!resp.ok) {
+				throw new Error("The last etude has expired. Play another before rating.")
+			  }
+			  return resp.json()
+			})
+			.then(function(cards) {
+			  if (cards.length == 0) {
+				throw new Error("Only One Interval, Two Intervals and Adaptive etudes can be rated.")
+			  }
+			  return Promise.all(cards.map(function(card) {
+				return fetch("/review/" + encodeURIComponent(user) + "/" + card + "/" + grade, {method: "POST"})
+			  }))
+			})
+			.then(function(resps) {
+			  if (!resps.every(function(resp) { return resp.ok })) {
+				alert("Play an etude with your name entered before rating.")
+			  }
+			})
+			.catch(function(err) {
+			  alert(err.message)
+			})
+		}
+
 		function downloadEtude() {
           var url = etudeURL()
 		  if (url == "") {
@@ -850,3 +1742,163 @@ func indexJS() (script *HtmlTree) {
 	`)
 	return
 }
+
+// playerJS returns the Web Audio player shared by the index and lesson
+// pages. It plays the event lists served by eventsHndlr.
+func playerJS() (script *HtmlTree) {
+	script = Script("",
+		`
+		// The Web Audio player. It plays spans of an event list, each
+		// {from, to} in seconds, one after another, scheduling notes a
+		// little ahead of the audio clock. The state of the current
+		// playback is in playing; spans records when each span began so
+		// the player can tell where in the etude it is.
+		var audio = null
+		var playing = null
+		var scheduleAhead = 0.2 // seconds
+		var scheduleEvery = 25  // milliseconds
+
+		// audioContext returns the page's AudioContext, creating it if
+		// need be. Browsers only let it run after a user action.
+		function audioContext() {
+			if (audio == null) {
+			  audio = new (window.AudioContext || window.webkitAudioContext)()
+			}
+			return audio
+		}
+
+		// playEvents plays segments of events in turn, repeating the last
+		// segment until stopped if loop is true. If onTime isn't null, the
+		// player calls it with the time in the etude as it plays.
+		function playEvents(events, segments, loop, onTime) {
+			stopEvents()
+			var ctx = audioContext()
+			ctx.resume()
+			var at = ctx.currentTime + 0.1
+			playing = {
+			  events: events,
+			  segments: segments,
+			  loop: loop,
+			  onTime: onTime,
+			  segment: 0,
+			  next: 0, // index of the next note to consider
+			  offset: at, // audio time at which the segment starts
+			  spans: [{at: at, from: segments[0].from}],
+			  voices: [],
+			  timer: setInterval(scheduleEvents, scheduleEvery),
+			}
+			scheduleEvents()
+		}
+
+		// scheduleEvents schedules the notes that start before the audio
+		// clock is scheduleAhead seconds further on. Notes already sounding
+		// when a segment starts, such as a drone, are cut to fit it.
+		function scheduleEvents() {
+			var p = playing
+			var now = audio.currentTime
+			var horizon = now + scheduleAhead
+			if (p.onTime != null) {
+			  p.onTime(etudeTime(now))
+			}
+			var notes = p.events.notes
+			while (p.segment < p.segments.length) {
+			  var seg = p.segments[p.segment]
+			  for (; p.next < notes.length && notes[p.next].t < seg.to; p.next++) {
+				var n = notes[p.next]
+				if (n.t + n.d <= seg.from) {
+				  continue
+				}
+				var start = Math.max(n.t, seg.from)
+				var when = p.offset + start - seg.from
+				if (when > horizon) {
+				  return
+				}
+				playNote(n, when, Math.min(n.t + n.d, seg.to) - start)
+			  }
+			  var end = p.offset + seg.to - seg.from
+			  if (end > horizon) {
+				return
+			  }
+			  if (!(p.loop && p.segment == p.segments.length - 1)) {
+				p.segment++
+			  }
+			  p.next = 0
+			  p.offset = end
+			  if (p.segment < p.segments.length) {
+				p.spans.push({at: end, from: p.segments[p.segment].from})
+				p.spans = p.spans.slice(-4)
+			  }
+			}
+			if (now > p.offset) {
+			  stopEvents()
+			}
+		}
+
+		// etudeTime returns the time in the etude being played at audio
+		// time t.
+		function etudeTime(t) {
+			var spans = playing.spans
+			var i = spans.length - 1
+			while (i > 0 && spans[i].at > t) {
+			  i--
+			}
+			return spans[i].from + t - spans[i].at
+		}
+
+		// waveForms are the oscillator types used for each family of eight
+		// General Midi programs; decaying marks the families whose notes
+		// die away like a piano's or a guitar's rather than sustaining.
+		var waveForms = ["triangle", "sine", "square", "triangle", "triangle", "sawtooth", "sawtooth", "sawtooth",
+		  "square", "sine", "sawtooth", "triangle", "triangle", "triangle", "sine", "square"]
+		var decaying = [true, true, false, true, true, false, false, false,
+		  false, false, false, false, false, true, true, true]
+
+		// playNote sounds note n of an event list at audio time when for
+		// dur seconds. Percussion, on channel 10, is a short click.
+		function playNote(n, when, dur) {
+			var ctx = audio
+			var osc = ctx.createOscillator()
+			var gain = ctx.createGain()
+			var family = Math.floor(n.prog / 8)
+			var peak = 0.3 * n.a
+			var sustain = decaying[family] ? 0 : 0.6 * peak
+			osc.type = waveForms[family]
+			if (n.ch == 9) {
+			  osc.type = "square"
+			  dur = 0.03
+			  sustain = 0
+			}
+			osc.frequency.value = 440 * Math.pow(2, (n.p - 69) / 12)
+			gain.gain.setValueAtTime(0, when)
+			gain.gain.linearRampToValueAtTime(peak, when + 0.01)
+			gain.gain.setTargetAtTime(sustain, when + 0.01, 0.3)
+			gain.gain.setTargetAtTime(0, when + dur, 0.03)
+			osc.connect(gain)
+			gain.connect(ctx.destination)
+			osc.start(when)
+			osc.stop(when + dur + 0.2)
+			var voices = playing.voices
+			voices.push(osc)
+			osc.onended = function() {
+			  voices.splice(voices.indexOf(osc), 1)
+			}
+		}
+
+		// stopEvents silences the player.
+		function stopEvents() {
+			if (playing == null) {
+			  return
+			}
+			clearInterval(playing.timer)
+			playing.voices.slice().forEach(function(osc) {
+			  try {
+				osc.stop()
+			  } catch (e) {
+				// older browsers refuse to stop an oscillator twice
+			  }
+			})
+			playing = null
+		}
+	`)
+	return
+}